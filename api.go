@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// keywordInfo describes a tracked keyword in API responses.
+type keywordInfo struct {
+	Keyword string `json:"keyword"`
+	Label   string `json:"label"`
+	Group   string `json:"group"`
+}
+
+// keywordsRequest is the body of POST and DELETE requests to the keywords API.
+type keywordsRequest struct {
+	Keywords []string `json:"keywords"`
+}
+
+// keywordsHandler lists the tracked keywords on GET, and adds or removes
+// keywords on POST or DELETE requests carrying the bearer token. Changes
+// aren't possible if token is empty.
+func keywordsHandler(e *Exporter, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			kw := e.Keywords()
+			res := struct {
+				Keywords []keywordInfo `json:"keywords"`
+			}{Keywords: []keywordInfo{}}
+			for t, k := range kw {
+				res.Keywords = append(res.Keywords, keywordInfo{Keyword: t, Label: k.label, Group: k.group})
+			}
+			sort.Slice(res.Keywords, func(i, j int) bool { return res.Keywords[i].Keyword < res.Keywords[j].Keyword })
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(res)
+			return
+		case http.MethodPost, http.MethodDelete:
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "Only GET, POST and DELETE requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if token == "" {
+			http.Error(w, fmt.Sprintf("Keywords can't be changed unless %s is set", envReloadToken), http.StatusForbidden)
+			return
+		}
+		if !authorized(w, r, token) {
+			return
+		}
+
+		// DELETE requests may list keywords in the query string instead of
+		// the body.
+		req := keywordsRequest{Keywords: r.URL.Query()["keyword"]}
+		if len(req.Keywords) == 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("Error decoding request: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if len(req.Keywords) == 0 {
+			http.Error(w, "No keywords provided", http.StatusBadRequest)
+			return
+		}
+
+		var add, remove []string
+		if r.Method == http.MethodPost {
+			add = req.Keywords
+		} else {
+			remove = req.Keywords
+		}
+
+		reloadMtx.Lock()
+		defer reloadMtx.Unlock()
+		old := e.Keywords()
+		w.Header().Set("Content-Type", "application/json")
+		if err := e.UpdateKeywords(add, remove); err != nil {
+			logError("Error updating keywords", "remote_addr", r.RemoteAddr, "err", err)
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(&reloadResult{Status: "error", Error: err.Error(), Added: []string{}, Removed: []string{}, Keywords: len(e.Keywords())})
+			return
+		}
+		res := diffKeywords(old, e.Keywords())
+		logInfo("Updated keywords", "remote_addr", r.RemoteAddr, "keywords", res.Keywords, "added", len(res.Added), "removed", len(res.Removed))
+		json.NewEncoder(w).Encode(res)
+	})
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// clientApp returns the name of the application t was posted with, which
+// Twitter gives as a link to the application's website.
+func clientApp(t *twitter.Tweet) (string, bool) {
+	app := strings.TrimSpace(htmlText(t.Source))
+	return app, app != ""
+}
+
+// countClientApp counts the application t was posted with, limited by l.
+// Applications which aren't allowed are counted as __other__.
+func (e *Exporter) countClientApp(l *labelLimit, t *twitter.Tweet) {
+	app, ok := clientApp(t)
+	if !ok {
+		return
+	}
+	label, ok := l.label(app)
+	if !ok {
+		label = otherLabel
+	}
+	e.clientApps.WithLabelValues(e.series.values("twitter_stream_client_apps_total", label)...).Inc()
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	awsMetadataURL          = "http://169.254.169.254/latest/"
+	awsContainerMetadataURL = "http://169.254.170.2"
+)
+
+// awsCredentials are the keys used to sign requests to AWS.
+type awsCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// awsSecretSource reads credentials stored as a JSON object in either an AWS
+// Secrets Manager secret or an SSM parameter, authenticating with keys from
+// the environment or the instance's IAM role.
+type awsSecretSource struct {
+	service  string
+	secretID string
+	region   string
+	client   *http.Client
+	// stsURL is the STS endpoint used to exchange web identity tokens.
+	stsURL string
+
+	mtx   sync.Mutex
+	creds *awsCredentials
+}
+
+// newAWSSecretSource returns a source for secretID using service, which is
+// either "secretsmanager" or "ssm".
+func newAWSSecretSource(service, secretID string) (*awsSecretSource, error) {
+	if secretID == "" {
+		return nil, fmt.Errorf("No secret ID provided, please set -credentials.secret-id")
+	}
+	s := &awsSecretSource{
+		service:  service,
+		secretID: secretID,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+	s.region = os.Getenv("AWS_REGION")
+	if s.region == "" {
+		s.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if s.region == "" {
+		b, err := s.metadata("meta-data/placement/region")
+		if err != nil {
+			return nil, fmt.Errorf("No AWS region provided and unable to query instance metadata, please set AWS_REGION: %v", err)
+		}
+		s.region = string(b)
+	}
+	s.stsURL = fmt.Sprintf("https://sts.%s.amazonaws.com/", s.region)
+	return s, nil
+}
+
+// fetch implements credentialSource.
+func (s *awsSecretSource) fetch() (map[string]string, string, error) {
+	var value, version string
+	switch s.service {
+	case "secretsmanager":
+		var resp struct {
+			SecretString string `json:"SecretString"`
+			VersionID    string `json:"VersionId"`
+		}
+		if err := s.call("secretsmanager.GetSecretValue", map[string]interface{}{"SecretId": s.secretID}, &resp); err != nil {
+			return nil, "", err
+		}
+		value, version = resp.SecretString, resp.VersionID
+	case "ssm":
+		var resp struct {
+			Parameter struct {
+				Value   string `json:"Value"`
+				Version int64  `json:"Version"`
+			} `json:"Parameter"`
+		}
+		if err := s.call("AmazonSSM.GetParameter", map[string]interface{}{"Name": s.secretID, "WithDecryption": true}, &resp); err != nil {
+			return nil, "", err
+		}
+		value, version = resp.Parameter.Value, strconv.FormatInt(resp.Parameter.Version, 10)
+	}
+	secret := map[string]string{}
+	if err := json.Unmarshal([]byte(value), &secret); err != nil {
+		return nil, "", fmt.Errorf("%s does not contain a JSON object of strings: %v", s.secretID, err)
+	}
+	return secret, version, nil
+}
+
+// call invokes an AWS JSON API action and decodes the response into out.
+func (s *awsSecretSource) call(target string, in, out interface{}) error {
+	creds, err := s.credentials()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	host := fmt.Sprintf("%s.%s.amazonaws.com", s.service, s.region)
+	req, err := http.NewRequest(http.MethodPost, "https://"+host+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	awsSign(req, body, creds, s.region, s.service, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("%s returned %s: %s %s", target, resp.Status, e.Type, e.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// credentials returns keys from the environment, or else by assuming a role
+// with a web identity token, as on EKS with IAM roles for service accounts,
+// or from the ECS task role or EC2 instance profile. Temporary keys are
+// cached until shortly before they expire.
+func (s *awsSecretSource) credentials() (*awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return &awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			Token:           os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.creds != nil && time.Now().Add(5*time.Minute).Before(s.creds.Expiration) {
+		return s.creds, nil
+	}
+
+	if path := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); path != "" {
+		creds, err := s.assumeRoleWithWebIdentity(path, os.Getenv("AWS_ROLE_ARN"), os.Getenv("AWS_ROLE_SESSION_NAME"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to assume role with web identity: %v", err)
+		}
+		s.creds = creds
+		return creds, nil
+	}
+
+	var b []byte
+	var err error
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		var resp *http.Response
+		resp, err = s.client.Get(awsContainerMetadataURL + uri)
+		if err == nil {
+			defer resp.Body.Close()
+			b, err = ioutil.ReadAll(resp.Body)
+		}
+	} else {
+		var role []byte
+		role, err = s.metadata("meta-data/iam/security-credentials/")
+		if err == nil {
+			b, err = s.metadata("meta-data/iam/security-credentials/" + strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0]))
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to find AWS credentials in the environment or instance role: %v", err)
+	}
+	creds := &awsCredentials{}
+	if err := json.Unmarshal(b, creds); err != nil {
+		return nil, fmt.Errorf("error decoding instance role credentials: %v", err)
+	}
+	s.creds = creds
+	return creds, nil
+}
+
+// assumeRoleWithWebIdentity exchanges the token in the file at path for
+// temporary credentials for role. The request isn't signed, as the token is
+// the proof of identity. The file is re-read each time, as it's rotated.
+// https://docs.aws.amazon.com/STS/latest/APIReference/API_AssumeRoleWithWebIdentity.html
+func (s *awsSecretSource) assumeRoleWithWebIdentity(path, role, session string) (*awsCredentials, error) {
+	if role == "" {
+		return nil, fmt.Errorf("AWS_ROLE_ARN must be set with AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+	token, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if session == "" {
+		session = fmt.Sprintf("twitter_stream_exporter-%d", time.Now().UnixNano())
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {role},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	resp, err := s.client.PostForm(s.stsURL, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		xml.NewDecoder(resp.Body).Decode(&e)
+		return nil, fmt.Errorf("AssumeRoleWithWebIdentity returned %s: %s %s", resp.Status, e.Code, e.Message)
+	}
+	var r struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("error decoding AssumeRoleWithWebIdentity response: %v", err)
+	}
+	c := r.Credentials
+	return &awsCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, Token: c.SessionToken, Expiration: c.Expiration}, nil
+}
+
+// metadata fetches a path from the EC2 instance metadata service using an
+// IMDSv2 session token.
+func (s *awsSecretSource) metadata(path string) ([]byte, error) {
+	c := &http.Client{Timeout: 2 * time.Second}
+	req, err := http.NewRequest(http.MethodPut, awsMetadataURL+"api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	token, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err = http.NewRequest(http.MethodGet, awsMetadataURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+	resp, err = c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("instance metadata returned %s for %s", resp.Status, path)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// awsSign adds a Signature Version 4 Authorization header to req, signing
+// all of its headers.
+// https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+func awsSign(req *http.Request, body []byte, creds *awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	values := map[string]string{"host": req.URL.Host}
+	if req.Host != "" {
+		values["host"] = req.Host
+	}
+	for k, vs := range req.Header {
+		if k == "Authorization" {
+			continue
+		}
+		trimmed := make([]string, len(vs))
+		for i, v := range vs {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		values[strings.ToLower(k)] = strings.Join(trimmed, ",")
+	}
+	var names []string
+	for n := range values {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var headers bytes.Buffer
+	for _, n := range names {
+		fmt.Fprintf(&headers, "%s:%s\n", n, values[n])
+	}
+	signed := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payload := sha256.Sum256(body)
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		awsCanonicalQuery(req.URL.Query()),
+		headers.String(),
+		signed,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	toSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(canonicalHash[:])}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = awsHMAC(key, part)
+	}
+	signature := hex.EncodeToString(awsHMAC(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signed, signature))
+}
+
+// awsCanonicalQuery returns q sorted by name and then value, with each
+// escaped as SigV4 requires.
+func awsCanonicalQuery(q url.Values) string {
+	var params [][2]string
+	for k, vs := range q {
+		for _, v := range vs {
+			params = append(params, [2]string{awsEscape(k), awsEscape(v)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+	parts := make([]string, len(params))
+	for i, p := range params {
+		parts[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes everything but the unreserved characters of
+// RFC 3986.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func awsHMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
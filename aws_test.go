@@ -0,0 +1,174 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Requests from the AWS Signature Version 4 test suite, and the IAM example
+// from the signing documentation, with the signatures they expect.
+// https://docs.aws.amazon.com/general/latest/gr/signature-v4-test-suite.html
+var awsSignTests = []struct {
+	name    string
+	method  string
+	url     string
+	headers map[string]string
+	body    string
+	service string
+	want    string
+}{
+	{
+		name:   "get-vanilla",
+		method: "GET",
+		url:    "https://example.amazonaws.com/",
+		want:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+	},
+	{
+		name:   "get-vanilla-query-order-key-case",
+		method: "GET",
+		url:    "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+		want:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+	},
+	{
+		name:    "get-header-value-trim",
+		method:  "GET",
+		url:     "https://example.amazonaws.com/",
+		headers: map[string]string{"My-Header1": " value1", "My-Header2": ` "a   b   c"`},
+		want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;my-header1;my-header2;x-amz-date, Signature=acc3ed3afb60bb290fc8d2dd0098b9911fcaa05412b367055dee359757a9c736",
+	},
+	{
+		name:   "post-vanilla",
+		method: "POST",
+		url:    "https://example.amazonaws.com/",
+		want:   "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+	},
+	{
+		name:    "post-x-www-form-urlencoded",
+		method:  "POST",
+		url:     "https://example.amazonaws.com/",
+		headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+		body:    "Param1=value1",
+		want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+	},
+	{
+		name:    "iam-list-users",
+		method:  "GET",
+		url:     "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+		headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+		service: "iam",
+		want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+	},
+}
+
+func TestAWSSign(t *testing.T) {
+	creds := &awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	for _, tt := range awsSignTests {
+		req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		service := tt.service
+		if service == "" {
+			service = "service"
+		}
+		awsSign(req, []byte(tt.body), creds, "us-east-1", service, now)
+		if got := req.Header.Get("Authorization"); got != tt.want {
+			t.Errorf("%s: got Authorization\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+		if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+			t.Errorf("%s: got X-Amz-Date %q", tt.name, got)
+		}
+	}
+}
+
+func TestAWSSignSessionToken(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	awsSign(req, nil, &awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", Token: "session"}, "us-east-1", "service", time.Now())
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "session" {
+		t.Errorf("got X-Amz-Security-Token %q, want %q", got, "session")
+	}
+	if !strings.Contains(req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-date;x-amz-security-token,") {
+		t.Errorf("session token isn't signed: %s", req.Header.Get("Authorization"))
+	}
+}
+
+func TestAWSWebIdentityCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("eyJhbGciOi.token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("Action") != "AssumeRoleWithWebIdentity" || r.FormValue("WebIdentityToken") != "eyJhbGciOi.token" ||
+			r.FormValue("RoleArn") != "arn:aws:iam::123456789012:role/exporter" || r.FormValue("RoleSessionName") != "test" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`<ErrorResponse><Error><Code>InvalidParameterValue</Code><Message>` + r.Form.Encode() + `</Message></Error></ErrorResponse>`))
+			return
+		}
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("AssumeRoleWithWebIdentity request was signed")
+		}
+		w.Write([]byte(`<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <SessionToken>session-token</SessionToken>
+      <SecretAccessKey>secret-key</SecretAccessKey>
+      <Expiration>2030-01-02T03:04:05Z</Expiration>
+      <AccessKeyId>ASIAEXAMPLE</AccessKeyId>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`))
+	}))
+	defer sts.Close()
+
+	for k, v := range map[string]string{
+		"AWS_ACCESS_KEY_ID":           "",
+		"AWS_WEB_IDENTITY_TOKEN_FILE": tokenFile,
+		"AWS_ROLE_ARN":                "arn:aws:iam::123456789012:role/exporter",
+		"AWS_ROLE_SESSION_NAME":       "test",
+	} {
+		old, ok := os.LookupEnv(k)
+		os.Setenv(k, v)
+		if ok {
+			defer os.Setenv(k, old)
+		} else {
+			defer os.Unsetenv(k)
+		}
+	}
+
+	s := &awsSecretSource{service: "secretsmanager", region: "us-east-1", client: sts.Client(), stsURL: sts.URL}
+	creds, err := s.credentials()
+	if err != nil {
+		t.Fatalf("credentials: %v", err)
+	}
+	want := awsCredentials{
+		AccessKeyID:     "ASIAEXAMPLE",
+		SecretAccessKey: "secret-key",
+		Token:           "session-token",
+		Expiration:      time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if *creds != want {
+		t.Errorf("got credentials %+v, want %+v", *creds, want)
+	}
+
+	os.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/other")
+	s.creds = nil
+	if _, err := s.credentials(); err == nil || !strings.Contains(err.Error(), "InvalidParameterValue") {
+		t.Errorf("got %v, want STS's error", err)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"golang.org/x/net/websocket"
+)
+
+// blueskySessionLifetime is how long an access token is reused for. Bluesky
+// limits how often sessions can be created, so one isn't created for every
+// reconnection.
+const blueskySessionLifetime = time.Hour
+
+// blueskySession is the result of logging in with an app password.
+// https://docs.bsky.app/docs/api/com-atproto-server-create-session
+type blueskySession struct {
+	AccessJwt string `json:"accessJwt"`
+	DID       string `json:"did"`
+	Handle    string `json:"handle"`
+
+	key     string
+	created time.Time
+}
+
+var (
+	blueskySessionMtx  sync.Mutex
+	lastBlueskySession *blueskySession
+)
+
+// getBlueskySession logs in to the PDS in c with its identifier and app
+// password, reusing the previous session if it was created with the same
+// credentials recently enough.
+func getBlueskySession(c twitterConfig) (*blueskySession, error) {
+	blueskySessionMtx.Lock()
+	defer blueskySessionMtx.Unlock()
+	key := c.blueskyPDS + "\x00" + c.blueskyIdentifier + "\x00" + c.blueskyPassword
+	if s := lastBlueskySession; s != nil && s.key == key && time.Since(s.created) < blueskySessionLifetime {
+		return s, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"identifier": c.blueskyIdentifier, "password": c.blueskyPassword})
+	if err != nil {
+		return nil, err
+	}
+	hc := &http.Client{Transport: proxyTransport(c.proxyURL), Timeout: 30 * time.Second}
+	resp, err := hc.Post(strings.TrimSuffix(c.blueskyPDS, "/")+"/xrpc/com.atproto.server.createSession", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Bluesky rejected the app password with %s", resp.Status)
+	}
+	s := &blueskySession{key: key, created: time.Now()}
+	if err := json.NewDecoder(resp.Body).Decode(s); err != nil {
+		return nil, fmt.Errorf("error parsing Bluesky session: %v", err)
+	}
+	lastBlueskySession = s
+	return s, nil
+}
+
+// testBlueskyAuth logs in with the configured app password and prints the
+// account it belongs to, returning the process's exit code.
+func testBlueskyAuth(c twitterConfig) int {
+	s, err := getBlueskySession(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error verifying credentials: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Authenticated as @%s (%s)\n", s.Handle, s.DID)
+	return 0
+}
+
+// blueskyFirehoseURL returns the URL of the Jetstream subscription in c,
+// limited to posts.
+func blueskyFirehoseURL(c twitterConfig) (*url.URL, error) {
+	u, err := url.Parse(c.blueskyURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	q := u.Query()
+	q.Set("wantedCollections", "app.bsky.feed.post")
+	u.RawQuery = q.Encode()
+	return u, nil
+}
+
+// blueskyEvent is the subset of a Jetstream event which is exported.
+// https://github.com/bluesky-social/jetstream
+type blueskyEvent struct {
+	DID    string `json:"did"`
+	Kind   string `json:"kind"`
+	Commit struct {
+		Operation  string      `json:"operation"`
+		Collection string      `json:"collection"`
+		RKey       string      `json:"rkey"`
+		Record     blueskyPost `json:"record"`
+	} `json:"commit"`
+}
+
+// blueskyPost is an app.bsky.feed.post record.
+type blueskyPost struct {
+	Text      string   `json:"text"`
+	Langs     []string `json:"langs"`
+	CreatedAt string   `json:"createdAt"`
+	// Facets mark up ranges of the text, such as hashtags.
+	// https://docs.bsky.app/docs/advanced-guides/post-richtext
+	Facets []struct {
+		Features []struct {
+			Type string `json:"$type"`
+			Tag  string `json:"tag"`
+		} `json:"features"`
+	} `json:"facets"`
+}
+
+// tweet converts a post to a tweet, so that it can be counted in the same
+// way. Hashtags become hashtag entities, so that keywords match them as they
+// do on Twitter, but mentions are left to the word matcher as posts only
+// identify mentioned users by their DID. Authors are identified by their DID
+// too.
+func (ev *blueskyEvent) tweet() *twitter.Tweet {
+	p := ev.Commit.Record
+	t := &twitter.Tweet{
+		IDStr:    "at://" + ev.DID + "/" + ev.Commit.Collection + "/" + ev.Commit.RKey,
+		Text:     p.Text,
+		User:     &twitter.User{IDStr: ev.DID, ScreenName: ev.DID},
+		Entities: &twitter.Entities{},
+	}
+	if len(p.Langs) > 0 {
+		t.Lang = p.Langs[0]
+	}
+	if ts, err := time.Parse(time.RFC3339, p.CreatedAt); err == nil {
+		t.CreatedAt = ts.Format(time.RubyDate)
+	}
+	// Not every client adds tag facets, so hashtags in the text count too.
+	// Tags can also be attached to a post without appearing in its text.
+	seen := map[string]bool{}
+	for _, w := range tokenize(p.Text) {
+		if len(w) > 1 && w[0] == '#' {
+			t.Entities.Hashtags = append(t.Entities.Hashtags, twitter.HashtagEntity{Text: w[1:]})
+			seen[strings.ToLower(w[1:])] = true
+		}
+	}
+	for _, f := range p.Facets {
+		for _, ft := range f.Features {
+			if ft.Type == "app.bsky.richtext.facet#tag" && ft.Tag != "" && !seen[strings.ToLower(ft.Tag)] {
+				t.Entities.Hashtags = append(t.Entities.Hashtags, twitter.HashtagEntity{Text: ft.Tag})
+				seen[strings.ToLower(ft.Tag)] = true
+			}
+		}
+	}
+	return t
+}
+
+// blueskySource delivers new posts from the firehose. The firehose can't be
+// filtered by keyword, so in filter mode posts which don't match any keyword
+// are dropped before they reach the exporter.
+type blueskySource struct {
+	msgs chan interface{}
+	o    streamObserver
+	// status is the status of the handshake response, which is only known
+	// once it has succeeded.
+	status int
+
+	ws   *websocket.Conn
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// Connect implements TweetSource, subscribing to the firehose in c.
+func (s *blueskySource) Connect(c twitterConfig, o streamObserver) error {
+	session, err := getBlueskySession(c)
+	if err != nil {
+		return err
+	}
+	u, err := blueskyFirehoseURL(c)
+	if err != nil {
+		return err
+	}
+	s.o = o
+	header := http.Header{"Authorization": {"Bearer " + session.AccessJwt}}
+	// Reads count as stream activity and the stream is disconnected once
+	// the connection ends, as for the HTTP streams.
+	ws, err := dialWebSocket(u, header, c.proxyURL, func(conn net.Conn) io.ReadWriteCloser {
+		return &closeNotifier{ReadCloser: conn, read: o.streamActivity, fn: func() { o.setConnected(false) }}
+	})
+	if err != nil {
+		o.setConnected(false)
+		return err
+	}
+	s.status = http.StatusSwitchingProtocols
+	o.streamStatus(s.status)
+	o.setConnected(true)
+
+	var m *matcher
+	if c.mode != "sample" {
+		m = newMatcher(buildKeywords(c), c.foldDiacritics)
+	}
+	s.msgs = make(chan interface{})
+	s.ws = ws
+	s.done = make(chan struct{})
+	s.wg.Add(1)
+	go s.receive(m)
+	return nil
+}
+
+// receive delivers posts until the connection ends or the stream is
+// stopped. Posts are only delivered if they match m, unless it's nil.
+func (s *blueskySource) receive(m *matcher) {
+	defer s.wg.Done()
+	defer close(s.msgs)
+	defer s.ws.Close()
+	for {
+		msg, err := readWebSocketMessage(s.ws)
+		if err != nil {
+			select {
+			case <-s.done:
+			default:
+				s.send(err)
+			}
+			return
+		}
+		ev := &blueskyEvent{}
+		if err := json.Unmarshal(msg, ev); err != nil {
+			logWarn("Error parsing Bluesky event", "err", err)
+			s.o.parseError()
+			continue
+		}
+		if ev.Kind != "commit" || ev.Commit.Operation != "create" || ev.Commit.Collection != "app.bsky.feed.post" {
+			continue
+		}
+		t := ev.tweet()
+		if m != nil && len(findMentions(m, t, func(keyword, string) {})) == 0 {
+			continue
+		}
+		recordTweet(s.o, t)
+		s.send(t)
+	}
+}
+
+// send delivers msg unless the stream has been stopped.
+func (s *blueskySource) send(msg interface{}) {
+	select {
+	case s.msgs <- msg:
+	case <-s.done:
+	}
+}
+
+// Stop implements TweetSource, closing the stream and waiting for it to
+// finish.
+func (s *blueskySource) Stop() {
+	s.once.Do(func() {
+		close(s.done)
+		s.ws.Close()
+	})
+	s.wg.Wait()
+}
+
+// Messages implements TweetSource.
+func (s *blueskySource) Messages() <-chan interface{} {
+	return s.msgs
+}
+
+// lastStatus implements statusReporter.
+func (s *blueskySource) lastStatus() int {
+	return s.status
+}
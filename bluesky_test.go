@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestBlueskyHashtags(t *testing.T) {
+	for _, tt := range []struct {
+		track []string
+		event string
+		want  []string
+	}{
+		{
+			track: []string{"golang"},
+			event: `{"kind":"commit","commit":{"record":{"text":"I love #golang"}}}`,
+			want:  []string{"golang:hashtag"},
+		},
+		{
+			track: []string{"#golang"},
+			event: `{"kind":"commit","commit":{"record":{"text":"I love #golang"}}}`,
+			want:  []string{"#golang:word"},
+		},
+		{
+			track: []string{"golang"},
+			event: `{"kind":"commit","commit":{"record":{"text":"I love #GoLang and golang"}}}`,
+			want:  []string{"golang:hashtag", "golang:word"},
+		},
+		{
+			// Tags needn't appear in the text.
+			track: []string{"golang"},
+			event: `{"kind":"commit","commit":{"record":{"text":"I love Go","facets":[{"features":[{"$type":"app.bsky.richtext.facet#tag","tag":"golang"}]}]}}}`,
+			want:  []string{"golang:hashtag"},
+		},
+		{
+			// A tag facet for a hashtag in the text isn't counted twice.
+			track: []string{"golang"},
+			event: `{"kind":"commit","commit":{"record":{"text":"#golang","facets":[{"features":[{"$type":"app.bsky.richtext.facet#tag","tag":"golang"}]}]}}}`,
+			want:  []string{"golang:hashtag"},
+		},
+		{
+			track: []string{"golang"},
+			event: `{"kind":"commit","commit":{"record":{"text":"I love #rustlang"}}}`,
+		},
+	} {
+		ev := &blueskyEvent{}
+		if err := json.Unmarshal([]byte(tt.event), ev); err != nil {
+			t.Fatal(err)
+		}
+		m := newMatcher(buildKeywords(twitterConfig{track: tt.track}), false)
+		var got []string
+		findMentions(m, ev.tweet(), func(k keyword, matchType string) { got = append(got, k.label+":"+matchType) })
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("track %q, event %s: got matches %q, want %q", tt.track, tt.event, got, tt.want)
+		}
+	}
+}
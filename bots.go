@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// botMinFriends is the number of accounts a user must follow before their
+// follower ratio counts as a bot signal, so that new but genuine accounts
+// which follow a few friends aren't flagged.
+const botMinFriends = 50
+
+// botDetector decides whether the author of a tweet looks automated. Each
+// heuristic which applies to the author is a signal, and authors with at
+// least minSignals signals are suspected bots.
+type botDetector struct {
+	minSignals       int
+	maxAccountAge    time.Duration
+	minFollowerRatio float64
+	sources          map[string]bool
+}
+
+// newBotDetector returns the heuristics configured in c, or nil if they're
+// disabled.
+func newBotDetector(c twitterConfig) *botDetector {
+	if !c.botHeuristics {
+		return nil
+	}
+	return &botDetector{
+		minSignals:       c.botMinSignals,
+		maxAccountAge:    c.botMaxAccountAge,
+		minFollowerRatio: c.botMinFollowerRatio,
+		sources:          lowerSet(c.botSources),
+	}
+}
+
+// signals returns the number of heuristics which apply to the author of t:
+// a default profile image, far fewer followers than friends, a new account,
+// and posting with a known automation tool.
+func (b *botDetector) signals(t *twitter.Tweet, now time.Time) int {
+	u := t.User
+	n := 0
+	if u.DefaultProfileImage {
+		n++
+	}
+	if u.FriendsCount >= botMinFriends && float64(u.FollowersCount)/float64(u.FriendsCount) < b.minFollowerRatio {
+		n++
+	}
+	if created, err := time.Parse(time.RubyDate, u.CreatedAt); err == nil && now.Sub(created) < b.maxAccountAge {
+		n++
+	}
+	if app, ok := clientApp(t); ok && b.sources[strings.ToLower(app)] {
+		n++
+	}
+	return n
+}
+
+// suspected reports whether the author of t is a suspected bot.
+func (b *botDetector) suspected(t *twitter.Tweet) bool {
+	return t.User != nil && b.signals(t, time.Now()) >= b.minSignals
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// overflowLabel is the label value given to every label of a series beyond
+// a seriesLimit's cap.
+const overflowLabel = "__overflow__"
+
+// seriesLimit caps the number of label combinations of each metric, so that
+// a flood of tweets with new hashtags or languages can't create an unbounded
+// number of series. Combinations which haven't been used for idle are
+// forgotten to make room for new ones, in step with -metrics.expire-after.
+type seriesLimit struct {
+	max      int
+	idle     time.Duration
+	overflow *prometheus.CounterVec
+
+	mtx     sync.Mutex
+	metrics map[string]*metricSeries
+}
+
+// metricSeries holds when each label combination of a metric was last used.
+type metricSeries struct {
+	lastUsed map[string]time.Time
+	pruned   time.Time
+}
+
+// newSeriesLimit returns a limit of max combinations per metric, counting
+// those folded into __overflow__ in overflow. A max of zero is unlimited.
+func newSeriesLimit(max int, idle time.Duration, overflow *prometheus.CounterVec) *seriesLimit {
+	return &seriesLimit{max: max, idle: idle, overflow: overflow, metrics: map[string]*metricSeries{}}
+}
+
+// values returns the label values to use for lvs in metric. Once a metric
+// has max combinations, new ones are all labelled __overflow__.
+func (l *seriesLimit) values(metric string, lvs ...string) []string {
+	if l.max <= 0 {
+		return lvs
+	}
+	key := strings.Join(lvs, "\xff")
+	now := time.Now()
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	m := l.metrics[metric]
+	if m == nil {
+		m = &metricSeries{lastUsed: map[string]time.Time{}, pruned: now}
+		l.metrics[metric] = m
+	}
+	if _, ok := m.lastUsed[key]; !ok && len(m.lastUsed) >= l.max {
+		m.prune(now, l.idle)
+	}
+	if _, ok := m.lastUsed[key]; ok || len(m.lastUsed) < l.max {
+		m.lastUsed[key] = now
+		return lvs
+	}
+	l.overflow.WithLabelValues(metric).Inc()
+	folded := make([]string, len(lvs))
+	for i := range folded {
+		folded[i] = overflowLabel
+	}
+	return folded
+}
+
+// prune forgets combinations which haven't been used for idle. It does
+// nothing if idle is zero, or more often than every idle/10, as it has to
+// look at every combination.
+func (m *metricSeries) prune(now time.Time, idle time.Duration) {
+	if idle <= 0 || now.Sub(m.pruned) < idle/10 {
+		return
+	}
+	m.pruned = now
+	for key, used := range m.lastUsed {
+		if now.Sub(used) > idle {
+			delete(m.lastUsed, key)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// classifier labels the text of matching tweets with an external HTTP
+// service, such as a team's own sentiment or topic model.
+type classifier struct {
+	url         string
+	timeout     time.Duration
+	concurrency int
+	client      *http.Client
+	// sem holds a token for each request in flight. Tweets which arrive
+	// while it's full aren't classified, so that a slow service can't hold
+	// up the stream.
+	sem    chan struct{}
+	labels *labelLimit
+}
+
+// classifyRequest is the body POSTed to the classification service.
+type classifyRequest struct {
+	ID       string   `json:"id"`
+	Text     string   `json:"text"`
+	Lang     string   `json:"lang"`
+	Keywords []string `json:"keywords"`
+}
+
+// classifyResponse is the classification service's reply.
+type classifyResponse struct {
+	Label string `json:"label"`
+}
+
+// classifierStatusError is returned when the service replies with a status
+// other than 200.
+type classifierStatusError int
+
+func (e classifierStatusError) Error() string {
+	return fmt.Sprintf("classifier returned HTTP %d", int(e))
+}
+
+// newClassifier returns a classifier for the service in c. At most
+// classifierMaxLabels distinct labels are exported.
+func newClassifier(c twitterConfig) *classifier {
+	return &classifier{
+		url:         c.classifierURL,
+		timeout:     c.classifierTimeout,
+		concurrency: c.classifierConcurrency,
+		client:      &http.Client{Timeout: c.classifierTimeout},
+		sem:         make(chan struct{}, c.classifierConcurrency),
+		labels:      newLabelLimit(c.classifierMaxLabels, nil, nil),
+	}
+}
+
+// sameConfig reports whether cl was created from the classifier options in
+// c, so that it can be kept across reloads.
+func (cl *classifier) sameConfig(c twitterConfig) bool {
+	return cl.url == c.classifierURL && cl.timeout == c.classifierTimeout &&
+		cl.concurrency == c.classifierConcurrency && cl.labels.sameLimits(c.classifierMaxLabels, nil, nil)
+}
+
+// classify returns the service's label for s, which matched keywords.
+func (cl *classifier) classify(s *twitter.Tweet, keywords []string) (string, error) {
+	body, err := json.Marshal(classifyRequest{ID: s.IDStr, Text: s.Text, Lang: s.Lang, Keywords: keywords})
+	if err != nil {
+		return "", err
+	}
+	resp, err := cl.client.Post(cl.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", classifierStatusError(resp.StatusCode)
+	}
+	r := classifyResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return "", err
+	}
+	if r.Label == "" {
+		return "", fmt.Errorf("classifier returned no label")
+	}
+	return r.Label, nil
+}
+
+// classifierErrorReason describes err for the reason label of
+// twitter_stream_classifier_errors_total.
+func classifierErrorReason(err error) string {
+	if code, ok := err.(classifierStatusError); ok {
+		return fmt.Sprintf("http_%d", int(code))
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return "timeout"
+	}
+	if _, ok := err.(net.Error); ok {
+		return "network_error"
+	}
+	return "invalid_response"
+}
+
+// classifyTweet classifies s in the background and counts its label for
+// each keyword in matched.
+func (e *Exporter) classifyTweet(cl *classifier, s *twitter.Tweet, matched map[string]bool) {
+	select {
+	case cl.sem <- struct{}{}:
+	default:
+		e.classifierErrors.WithLabelValues(e.series.values("twitter_stream_classifier_errors_total", "busy")...).Inc()
+		return
+	}
+	keywords := make([]string, 0, len(matched))
+	for kw := range matched {
+		keywords = append(keywords, kw)
+	}
+	sort.Strings(keywords)
+	go func() {
+		defer func() { <-cl.sem }()
+		start := time.Now()
+		label, err := cl.classify(s, keywords)
+		e.classifierDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			e.classifierErrors.WithLabelValues(e.series.values("twitter_stream_classifier_errors_total", classifierErrorReason(err))...).Inc()
+			return
+		}
+		label, _ = cl.labels.label(label)
+		for _, kw := range keywords {
+			e.classifiedTweets.WithLabelValues(e.series.values("twitter_stream_classified_tweets_total", kw, label)...).Inc()
+		}
+	}()
+}
@@ -0,0 +1,1029 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// fileConfig is the structure of the YAML file passed to -config.file.
+type fileConfig struct {
+	Web struct {
+		ListenAddress   string        `yaml:"listen_address"`
+		TelemetryPath   string        `yaml:"telemetry_path"`
+		ConfigFile      string        `yaml:"config_file"`
+		SocketMode      string        `yaml:"socket_mode"`
+		SystemdSocket   bool          `yaml:"systemd_socket"`
+		ReadyMaxSilence time.Duration `yaml:"ready_max_silence"`
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+		ReadTimeout     time.Duration `yaml:"read_timeout"`
+		WriteTimeout    time.Duration `yaml:"write_timeout"`
+		IdleTimeout     time.Duration `yaml:"idle_timeout"`
+		MaxHeaderBytes  int           `yaml:"max_header_bytes"`
+		MaxRequests     int           `yaml:"max_requests"`
+	} `yaml:"web"`
+	Startup struct {
+		Retry bool `yaml:"retry"`
+	} `yaml:"startup"`
+	Source     string  `yaml:"source"`
+	SourceFile string  `yaml:"source_file"`
+	SourceRate float64 `yaml:"source_rate"`
+	Metrics    struct {
+		Namespace               string            `yaml:"namespace"`
+		ConstLabels             map[string]string `yaml:"const_labels"`
+		DisableGoCollector      bool              `yaml:"disable_go_collector"`
+		DisableProcessCollector bool              `yaml:"disable_process_collector"`
+		ExpireAfter             time.Duration     `yaml:"expire_after"`
+		MaxSeries               int               `yaml:"max_series"`
+	} `yaml:"metrics"`
+	Twitter struct {
+		AccessToken    string              `yaml:"access_token"`
+		AccessSecret   string              `yaml:"access_secret"`
+		ConsumerKey    string              `yaml:"consumer_key"`
+		ConsumerSecret string              `yaml:"consumer_secret"`
+		BearerToken    string              `yaml:"bearer_token"`
+		Mode           string              `yaml:"mode"`
+		ProxyURL       string              `yaml:"proxy_url"`
+		Track          []string            `yaml:"track"`
+		TrackFile      string              `yaml:"track_file"`
+		Groups         map[string][]string `yaml:"groups"`
+		Aliases        map[string][]string `yaml:"aliases"`
+		Exclude        []string            `yaml:"exclude"`
+		Follow         []string            `yaml:"follow"`
+		ListID         string              `yaml:"list_id"`
+		// ListRefreshInterval is zero if unset, so refreshing can only
+		// be disabled with the flag.
+		ListRefreshInterval time.Duration `yaml:"list_refresh_interval"`
+		// Locations maps names to south-west longitude and latitude
+		// followed by north-east longitude and latitude.
+		Locations       map[string][]float64 `yaml:"locations"`
+		Languages       []string             `yaml:"languages"`
+		Keywords        []keywordOptions     `yaml:"keywords"`
+		FoldDiacritics  bool                 `yaml:"fold_diacritics"`
+		CountQuoted     bool                 `yaml:"count_quoted"`
+		StartPaused     bool                 `yaml:"start_paused"`
+		Sentiment       bool                 `yaml:"sentiment"`
+		ProfileMentions bool                 `yaml:"profile_mentions"`
+		UniqueAuthors   bool                 `yaml:"unique_authors"`
+		SampleRate      float64              `yaml:"sample_rate"`
+		HashtagPairs    struct {
+			Enabled bool     `yaml:"enabled"`
+			Max     int      `yaml:"max"`
+			Allow   []string `yaml:"allow"`
+		} `yaml:"hashtag_pairs"`
+		TrendingHashtags struct {
+			Count    int           `yaml:"count"`
+			HalfLife time.Duration `yaml:"half_life"`
+		} `yaml:"trending_hashtags"`
+		LinkDomains struct {
+			Enabled bool     `yaml:"enabled"`
+			Max     int      `yaml:"max"`
+			Allow   []string `yaml:"allow"`
+			Deny    []string `yaml:"deny"`
+		} `yaml:"link_domains"`
+		ClientApps struct {
+			Enabled bool     `yaml:"enabled"`
+			Max     int      `yaml:"max"`
+			Allow   []string `yaml:"allow"`
+		} `yaml:"client_apps"`
+		Emoji struct {
+			Enabled bool     `yaml:"enabled"`
+			Max     int      `yaml:"max"`
+			Allow   []string `yaml:"allow"`
+		} `yaml:"emoji"`
+		BotHeuristics struct {
+			Enabled          bool          `yaml:"enabled"`
+			MinSignals       int           `yaml:"min_signals"`
+			MaxAccountAge    time.Duration `yaml:"max_account_age"`
+			MinFollowerRatio float64       `yaml:"min_follower_ratio"`
+			Sources          []string      `yaml:"sources"`
+		} `yaml:"bot_heuristics"`
+		Duplicates struct {
+			Enabled bool          `yaml:"enabled"`
+			Window  time.Duration `yaml:"window"`
+			Exclude bool          `yaml:"exclude"`
+		} `yaml:"duplicates"`
+		// IdleRestartAfter is zero if unset, so the watchdog can only be
+		// disabled with the flag.
+		IdleRestartAfter time.Duration `yaml:"idle_restart_after"`
+		PollInterval     time.Duration `yaml:"poll_interval"`
+	} `yaml:"twitter"`
+	Mastodon struct {
+		URL         string `yaml:"url"`
+		AccessToken string `yaml:"access_token"`
+	} `yaml:"mastodon"`
+	Bluesky struct {
+		URL         string `yaml:"url"`
+		PDSURL      string `yaml:"pds_url"`
+		Identifier  string `yaml:"identifier"`
+		AppPassword string `yaml:"app_password"`
+	} `yaml:"bluesky"`
+	Record struct {
+		Path    string        `yaml:"path"`
+		MaxSize int64         `yaml:"max_size"`
+		MaxAge  time.Duration `yaml:"max_age"`
+	} `yaml:"record"`
+	Classifier struct {
+		URL         string        `yaml:"url"`
+		Timeout     time.Duration `yaml:"timeout"`
+		Concurrency int           `yaml:"concurrency"`
+		MaxLabels   int           `yaml:"max_labels"`
+	} `yaml:"classifier"`
+	Credentials struct {
+		Source          string        `yaml:"source"`
+		SecretID        string        `yaml:"secret_id"`
+		RefreshInterval time.Duration `yaml:"refresh_interval"`
+	} `yaml:"credentials"`
+	Vault struct {
+		Address string `yaml:"address"`
+		Path    string `yaml:"path"`
+	} `yaml:"vault"`
+}
+
+// config is the exporter's configuration after merging the config file,
+// command line flags and environment.
+type config struct {
+	listenAddress string
+	metricsPath   string
+	trackFile     string
+	// systemdSocket serves on the socket passed by systemd socket
+	// activation instead of listenAddress.
+	systemdSocket bool
+	// socketMode is the permissions of the socket created when
+	// listenAddress is a unix:// path.
+	socketMode os.FileMode
+	// webConfigFile is an exporter-toolkit web configuration file enabling
+	// TLS and basic auth.
+	webConfigFile string
+	// readyMaxSilence is how long the stream may go without receiving data
+	// before the exporter is reported as not ready.
+	readyMaxSilence time.Duration
+	// startupRetry retries verifying the credentials at startup while
+	// Twitter is unavailable.
+	startupRetry bool
+	// shutdownTimeout is how long shutting down may wait for received
+	// tweets to be processed and HTTP requests to finish.
+	shutdownTimeout time.Duration
+	// readTimeout, writeTimeout, idleTimeout and maxHeaderBytes limit the
+	// HTTP server's connections, and maxRequests the number of concurrent
+	// scrapes. Zero timeouts and maxRequests are unlimited.
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	idleTimeout    time.Duration
+	maxHeaderBytes int
+	maxRequests    int
+	// recordPath is the directory raw messages are archived to, if any,
+	// in files rotated at recordMaxSize bytes or recordMaxAge.
+	recordPath    string
+	recordMaxSize int64
+	recordMaxAge  time.Duration
+	// idleRestartAfter is how long the stream may go without receiving data
+	// before it's restarted.
+	idleRestartAfter time.Duration
+	// listRefreshInterval is how often the members of the followed list
+	// are re-read.
+	listRefreshInterval time.Duration
+	twitter             twitterConfig
+	metrics             metricsConfig
+
+	credentialSource          string
+	credentialSecretID        string
+	credentialRefreshInterval time.Duration
+	vaultAddress              string
+	vaultPath                 string
+}
+
+// readConfigFile parses the YAML configuration file at path.
+func readConfigFile(path string) (*fileConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fc := &fileConfig{}
+	if err := unmarshalYAML(b, fc); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	return fc, nil
+}
+
+// unmarshalYAML decodes data into v, rejecting keys which don't match any
+// field. yaml.v2 names the type an unknown key was found in, which for the
+// anonymous structs in fileConfig is unreadably long, so it's left out.
+func unmarshalYAML(data []byte, v interface{}) error {
+	err := yaml.UnmarshalStrict(data, v)
+	if te, ok := err.(*yaml.TypeError); ok {
+		for i, e := range te.Errors {
+			if j := strings.Index(e, " not found in type "); j >= 0 {
+				te.Errors[i] = e[:j+len(" not found")]
+			}
+		}
+	}
+	return err
+}
+
+// Limits on the track and follow parameters imposed by Twitter.
+// https://dev.twitter.com/streaming/overview/request-parameters#track
+const (
+	maxTrackKeywords = 400
+	maxKeywordBytes  = 60
+	maxFollowUsers   = 5000
+)
+
+// loadConfig builds the exporter's configuration and checks that it's usable.
+func loadConfig() (*config, error) {
+	c, err := buildConfig()
+	if err != nil {
+		return nil, err
+	}
+	if errs := c.validate(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return c, nil
+}
+
+// buildConfig merges the exporter's configuration. Values from the config
+// file are used unless the equivalent flag was given on the command line, and
+// the Twitter credentials can be overridden from the environment.
+func buildConfig() (*config, error) {
+	fc := &fileConfig{}
+	if *configFile != "" {
+		var err error
+		if fc, err = readConfigFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	pick := func(name, fileValue string) string {
+		if set[name] || fileValue == "" {
+			return flag.Lookup(name).Value.String()
+		}
+		return fileValue
+	}
+	c := &config{
+		listenAddress: pick("web.listen-address", fc.Web.ListenAddress),
+		metricsPath:   pick("web.telemetry-path", fc.Web.TelemetryPath),
+		webConfigFile: pick("web.config.file", fc.Web.ConfigFile),
+		trackFile:     pick("twitter.track-file", fc.Twitter.TrackFile),
+		recordPath:    pick("record.path", fc.Record.Path),
+		recordMaxSize: *recordMaxSize,
+		recordMaxAge:  *recordMaxAge,
+		twitter: twitterConfig{
+			mode:        pick("twitter.mode", fc.Twitter.Mode),
+			track:       fc.Twitter.Track,
+			source:      pick("source", fc.Source),
+			mastodonURL: pick("mastodon.url", fc.Mastodon.URL),
+			blueskyURL:  pick("bluesky.url", fc.Bluesky.URL),
+			blueskyPDS:  pick("bluesky.pds-url", fc.Bluesky.PDSURL),
+			sourceFile:  pick("source.file", fc.SourceFile),
+			sourceRate:  *sourceRate,
+
+			classifierURL:         pick("classifier.url", fc.Classifier.URL),
+			classifierTimeout:     *classifierTimeout,
+			classifierConcurrency: *classifierConcurrency,
+			classifierMaxLabels:   *classifierMaxLabels,
+		},
+		metrics: metricsConfig{
+			namespace:   pick("metrics.namespace", fc.Metrics.Namespace),
+			constLabels: fc.Metrics.ConstLabels,
+
+			disableGoCollector:      fc.Metrics.DisableGoCollector,
+			disableProcessCollector: fc.Metrics.DisableProcessCollector,
+		},
+		credentialSource:          pick("credentials.source", fc.Credentials.Source),
+		credentialSecretID:        pick("credentials.secret-id", fc.Credentials.SecretID),
+		credentialRefreshInterval: *credentialsRefreshInterval,
+		vaultAddress:              pick("vault.address", fc.Vault.Address),
+		vaultPath:                 pick("vault.path", fc.Vault.Path),
+	}
+	mode, err := parseSocketMode(pick("web.socket-mode", fc.Web.SocketMode))
+	if err != nil {
+		return nil, err
+	}
+	c.socketMode = mode
+	if p := pick("twitter.proxy-url", fc.Twitter.ProxyURL); p != "" {
+		u, err := parseProxyURL(p)
+		if err != nil {
+			return nil, err
+		}
+		c.twitter.proxyURL = u
+	}
+	if !set["source.rate"] && fc.SourceRate != 0 {
+		c.twitter.sourceRate = fc.SourceRate
+	}
+	if !set["classifier.timeout"] && fc.Classifier.Timeout != 0 {
+		c.twitter.classifierTimeout = fc.Classifier.Timeout
+	}
+	if !set["classifier.concurrency"] && fc.Classifier.Concurrency != 0 {
+		c.twitter.classifierConcurrency = fc.Classifier.Concurrency
+	}
+	if !set["classifier.max-labels"] && fc.Classifier.MaxLabels != 0 {
+		c.twitter.classifierMaxLabels = fc.Classifier.MaxLabels
+	}
+	if !set["record.max-size"] && fc.Record.MaxSize != 0 {
+		c.recordMaxSize = fc.Record.MaxSize
+	}
+	if !set["record.max-age"] && fc.Record.MaxAge != 0 {
+		c.recordMaxAge = fc.Record.MaxAge
+	}
+	c.twitter.pollInterval = *pollInterval
+	if !set["twitter.poll-interval"] && fc.Twitter.PollInterval != 0 {
+		c.twitter.pollInterval = fc.Twitter.PollInterval
+	}
+	c.twitter.listID = pick("twitter.list-id", fc.Twitter.ListID)
+	c.listRefreshInterval = *listRefreshInterval
+	if !set["twitter.list-refresh-interval"] && fc.Twitter.ListRefreshInterval != 0 {
+		c.listRefreshInterval = fc.Twitter.ListRefreshInterval
+	}
+	c.metrics.expireAfter = *metricsExpireAfter
+	if !set["metrics.expire-after"] && fc.Metrics.ExpireAfter != 0 {
+		c.metrics.expireAfter = fc.Metrics.ExpireAfter
+	}
+	c.metrics.maxSeries = *metricsMaxSeries
+	if !set["metrics.max-series"] && fc.Metrics.MaxSeries != 0 {
+		c.metrics.maxSeries = fc.Metrics.MaxSeries
+	}
+	if set["metrics.disable-go-collector"] {
+		c.metrics.disableGoCollector = *disableGoCollector
+	}
+	if set["metrics.disable-process-collector"] {
+		c.metrics.disableProcessCollector = *disableProcessCollector
+	}
+	c.systemdSocket = fc.Web.SystemdSocket
+	if set["web.systemd-socket"] {
+		c.systemdSocket = *systemdSocket
+	}
+	c.startupRetry = fc.Startup.Retry
+	if set["startup.retry"] {
+		c.startupRetry = *startupRetry
+	}
+	c.shutdownTimeout = *shutdownTimeout
+	if !set["web.shutdown-timeout"] && fc.Web.ShutdownTimeout != 0 {
+		c.shutdownTimeout = fc.Web.ShutdownTimeout
+	}
+	c.readTimeout = *readTimeout
+	if !set["web.read-timeout"] && fc.Web.ReadTimeout != 0 {
+		c.readTimeout = fc.Web.ReadTimeout
+	}
+	c.writeTimeout = *writeTimeout
+	if !set["web.write-timeout"] && fc.Web.WriteTimeout != 0 {
+		c.writeTimeout = fc.Web.WriteTimeout
+	}
+	c.idleTimeout = *idleTimeout
+	if !set["web.idle-timeout"] && fc.Web.IdleTimeout != 0 {
+		c.idleTimeout = fc.Web.IdleTimeout
+	}
+	c.maxHeaderBytes = *maxHeaderBytes
+	if !set["web.max-header-bytes"] && fc.Web.MaxHeaderBytes != 0 {
+		c.maxHeaderBytes = fc.Web.MaxHeaderBytes
+	}
+	c.maxRequests = *maxRequests
+	if !set["web.max-requests"] && fc.Web.MaxRequests != 0 {
+		c.maxRequests = fc.Web.MaxRequests
+	}
+	c.readyMaxSilence = *readyMaxSilence
+	if !set["web.ready.max-silence"] && fc.Web.ReadyMaxSilence != 0 {
+		c.readyMaxSilence = fc.Web.ReadyMaxSilence
+	}
+	c.idleRestartAfter = *idleRestartAfter
+	if !set["twitter.idle-restart-after"] && fc.Twitter.IdleRestartAfter != 0 {
+		c.idleRestartAfter = fc.Twitter.IdleRestartAfter
+	}
+	if !set["credentials.refresh-interval"] && fc.Credentials.RefreshInterval != 0 {
+		c.credentialRefreshInterval = fc.Credentials.RefreshInterval
+	}
+	if set["metrics.const-labels"] {
+		labels, err := parseLabels(*metricsConstLabels)
+		if err != nil {
+			return nil, err
+		}
+		c.metrics.constLabels = labels
+	}
+	if c.credentialSource == "" && c.vaultPath != "" {
+		c.credentialSource = "vault"
+	}
+
+	// Credentials from an external store take precedence over the config file.
+	src, err := newCredentialSource(c)
+	if err != nil {
+		return nil, err
+	}
+	if src != nil {
+		secret, _, err := src.fetch()
+		if err != nil {
+			return nil, err
+		}
+		for key, dst := range map[string]*string{
+			"access_token":    &fc.Twitter.AccessToken,
+			"access_secret":   &fc.Twitter.AccessSecret,
+			"consumer_key":    &fc.Twitter.ConsumerKey,
+			"consumer_secret": &fc.Twitter.ConsumerSecret,
+			"bearer_token":    &fc.Twitter.BearerToken,
+		} {
+			if secret[key] != "" {
+				*dst = secret[key]
+			}
+		}
+	}
+	for _, cred := range []struct {
+		dst       *string
+		env       string
+		fileValue string
+	}{
+		{&c.twitter.accessToken, envAccessToken, fc.Twitter.AccessToken},
+		{&c.twitter.tokenSecret, envAccessSecret, fc.Twitter.AccessSecret},
+		{&c.twitter.consumerKey, envConsumerKey, fc.Twitter.ConsumerKey},
+		{&c.twitter.consumerSecret, envConsumerSecret, fc.Twitter.ConsumerSecret},
+		{&c.twitter.bearerToken, envBearerToken, fc.Twitter.BearerToken},
+		{&c.twitter.mastodonToken, envMastodonToken, fc.Mastodon.AccessToken},
+		{&c.twitter.blueskyIdentifier, envBlueskyID, fc.Bluesky.Identifier},
+		{&c.twitter.blueskyPassword, envBlueskyPass, fc.Bluesky.AppPassword},
+	} {
+		v, err := credential(cred.env, cred.fileValue)
+		if err != nil {
+			return nil, err
+		}
+		*cred.dst = v
+	}
+	if set["twitter.track"] || len(c.twitter.track) == 0 {
+		c.twitter.track = splitList(*track)
+	}
+	if c.trackFile != "" {
+		kw, err := readTrackFile(c.trackFile)
+		if err != nil {
+			return nil, err
+		}
+		c.twitter.track = append(c.twitter.track, kw...)
+	}
+	c.twitter.exclude = fc.Twitter.Exclude
+	if set["twitter.exclude"] {
+		c.twitter.exclude = splitList(*exclude)
+	}
+	c.twitter.foldDiacritics = fc.Twitter.FoldDiacritics
+	if set["twitter.fold-diacritics"] {
+		c.twitter.foldDiacritics = *foldDiacritics
+	}
+	c.twitter.follow = fc.Twitter.Follow
+	if set["twitter.follow"] {
+		c.twitter.follow = splitList(*follow)
+	}
+	var names []string
+	for name := range fc.Twitter.Locations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b, err := newGeoBox(name, fc.Twitter.Locations[name])
+		if err != nil {
+			return nil, err
+		}
+		c.twitter.locations = append(c.twitter.locations, b)
+	}
+	if set["twitter.locations"] {
+		boxes, err := parseLocations(*locations)
+		if err != nil {
+			return nil, err
+		}
+		c.twitter.locations = boxes
+	}
+	c.twitter.languages = fc.Twitter.Languages
+	if set["twitter.languages"] {
+		c.twitter.languages = splitList(*languages)
+	}
+	c.twitter.logSampleMatches = *logSampleMatches
+	c.twitter.sampleRate = *sampleRate
+	if !set["twitter.sample-rate"] && fc.Twitter.SampleRate != 0 {
+		c.twitter.sampleRate = fc.Twitter.SampleRate
+	}
+	c.twitter.hashtagPairs = fc.Twitter.HashtagPairs.Enabled
+	if set["twitter.hashtag-pairs"] {
+		c.twitter.hashtagPairs = *hashtagPairs
+	}
+	c.twitter.hashtagPairsMax = *hashtagPairsMax
+	if !set["twitter.hashtag-pairs.max"] && fc.Twitter.HashtagPairs.Max != 0 {
+		c.twitter.hashtagPairsMax = fc.Twitter.HashtagPairs.Max
+	}
+	c.twitter.hashtagPairsAllow = fc.Twitter.HashtagPairs.Allow
+	if set["twitter.hashtag-pairs.allow"] {
+		c.twitter.hashtagPairsAllow = splitList(*hashtagPairsAllow)
+	}
+	c.twitter.trendingHashtags = *trendingHashtags
+	if !set["twitter.trending-hashtags"] && fc.Twitter.TrendingHashtags.Count != 0 {
+		c.twitter.trendingHashtags = fc.Twitter.TrendingHashtags.Count
+	}
+	c.twitter.trendingHalfLife = *trendingHalfLife
+	if !set["twitter.trending-hashtags.half-life"] && fc.Twitter.TrendingHashtags.HalfLife != 0 {
+		c.twitter.trendingHalfLife = fc.Twitter.TrendingHashtags.HalfLife
+	}
+	c.twitter.linkDomains = fc.Twitter.LinkDomains.Enabled
+	if set["twitter.link-domains"] {
+		c.twitter.linkDomains = *linkDomains
+	}
+	c.twitter.linkDomainsMax = *linkDomainsMax
+	if !set["twitter.link-domains.max"] && fc.Twitter.LinkDomains.Max != 0 {
+		c.twitter.linkDomainsMax = fc.Twitter.LinkDomains.Max
+	}
+	c.twitter.linkDomainsAllow = fc.Twitter.LinkDomains.Allow
+	if set["twitter.link-domains.allow"] {
+		c.twitter.linkDomainsAllow = splitList(*linkDomainsAllow)
+	}
+	c.twitter.linkDomainsDeny = fc.Twitter.LinkDomains.Deny
+	if set["twitter.link-domains.deny"] {
+		c.twitter.linkDomainsDeny = splitList(*linkDomainsDeny)
+	}
+	c.twitter.clientApps = fc.Twitter.ClientApps.Enabled
+	if set["twitter.client-apps"] {
+		c.twitter.clientApps = *clientApps
+	}
+	c.twitter.clientAppsMax = *clientAppsMax
+	if !set["twitter.client-apps.max"] && fc.Twitter.ClientApps.Max != 0 {
+		c.twitter.clientAppsMax = fc.Twitter.ClientApps.Max
+	}
+	c.twitter.clientAppsAllow = fc.Twitter.ClientApps.Allow
+	if set["twitter.client-apps.allow"] {
+		c.twitter.clientAppsAllow = splitList(*clientAppsAllow)
+	}
+	c.twitter.emoji = fc.Twitter.Emoji.Enabled
+	if set["twitter.emoji"] {
+		c.twitter.emoji = *emoji
+	}
+	c.twitter.emojiMax = *emojiMax
+	if !set["twitter.emoji.max"] && fc.Twitter.Emoji.Max != 0 {
+		c.twitter.emojiMax = fc.Twitter.Emoji.Max
+	}
+	c.twitter.emojiAllow = fc.Twitter.Emoji.Allow
+	if set["twitter.emoji.allow"] {
+		c.twitter.emojiAllow = splitList(*emojiAllow)
+	}
+	c.twitter.uniqueAuthors = fc.Twitter.UniqueAuthors
+	if set["twitter.unique-authors"] {
+		c.twitter.uniqueAuthors = *uniqueAuthors
+	}
+	c.twitter.botHeuristics = fc.Twitter.BotHeuristics.Enabled
+	if set["twitter.bot-heuristics"] {
+		c.twitter.botHeuristics = *botHeuristics
+	}
+	c.twitter.botMinSignals = *botMinSignals
+	if !set["twitter.bot-heuristics.min-signals"] && fc.Twitter.BotHeuristics.MinSignals != 0 {
+		c.twitter.botMinSignals = fc.Twitter.BotHeuristics.MinSignals
+	}
+	c.twitter.botMaxAccountAge = *botMaxAccountAge
+	if !set["twitter.bot-heuristics.max-account-age"] && fc.Twitter.BotHeuristics.MaxAccountAge != 0 {
+		c.twitter.botMaxAccountAge = fc.Twitter.BotHeuristics.MaxAccountAge
+	}
+	c.twitter.botMinFollowerRatio = *botMinFollowerRatio
+	if !set["twitter.bot-heuristics.min-follower-ratio"] && fc.Twitter.BotHeuristics.MinFollowerRatio != 0 {
+		c.twitter.botMinFollowerRatio = fc.Twitter.BotHeuristics.MinFollowerRatio
+	}
+	c.twitter.botSources = fc.Twitter.BotHeuristics.Sources
+	if set["twitter.bot-heuristics.sources"] {
+		c.twitter.botSources = splitList(*botSources)
+	}
+	c.twitter.duplicates = fc.Twitter.Duplicates.Enabled
+	if set["twitter.duplicates"] {
+		c.twitter.duplicates = *duplicates
+	}
+	c.twitter.duplicatesWindow = *duplicatesWindow
+	if !set["twitter.duplicates.window"] && fc.Twitter.Duplicates.Window != 0 {
+		c.twitter.duplicatesWindow = fc.Twitter.Duplicates.Window
+	}
+	c.twitter.duplicatesExclude = fc.Twitter.Duplicates.Exclude
+	if set["twitter.duplicates.exclude"] {
+		c.twitter.duplicatesExclude = *duplicatesExclude
+	}
+	c.twitter.profileMentions = fc.Twitter.ProfileMentions
+	if set["twitter.profile-mentions"] {
+		c.twitter.profileMentions = *profileMentions
+	}
+	c.twitter.sentiment = fc.Twitter.Sentiment
+	if set["twitter.sentiment"] {
+		c.twitter.sentiment = *sentiment
+	}
+	c.twitter.countQuoted = fc.Twitter.CountQuoted
+	if set["twitter.count-quoted"] {
+		c.twitter.countQuoted = *countQuoted
+	}
+	c.twitter.startPaused = fc.Twitter.StartPaused
+	if set["twitter.start-paused"] {
+		c.twitter.startPaused = *startPaused
+	}
+	c.twitter.groups = fc.Twitter.Groups
+	var groups []string
+	for g := range c.twitter.groups {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		c.twitter.track = append(c.twitter.track, c.twitter.groups[g]...)
+	}
+	c.twitter.keywords = fc.Twitter.Keywords
+	for _, o := range c.twitter.keywords {
+		if !containsFold(c.twitter.track, o.Keyword) {
+			c.twitter.track = append(c.twitter.track, o.Keyword)
+		}
+	}
+	c.twitter.aliases = fc.Twitter.Aliases
+	var canonicals []string
+	for k := range c.twitter.aliases {
+		canonicals = append(canonicals, k)
+	}
+	sort.Strings(canonicals)
+	for _, k := range canonicals {
+		if !containsFold(c.twitter.track, k) {
+			c.twitter.track = append(c.twitter.track, k)
+		}
+		c.twitter.track = append(c.twitter.track, c.twitter.aliases[k]...)
+	}
+
+	return c, nil
+}
+
+// validate returns every problem which would prevent the exporter from
+// running with c.
+func (c *config) validate() []error {
+	errs := c.twitter.validate()
+	if c.twitter.mode == "poll" {
+		if c.idleRestartAfter > 0 && c.idleRestartAfter <= c.twitter.pollInterval {
+			errs = append(errs, fmt.Errorf("-twitter.idle-restart-after must be longer than -twitter.poll-interval"))
+		}
+		// Nothing is received between polls.
+		if c.readyMaxSilence <= c.twitter.pollInterval {
+			errs = append(errs, fmt.Errorf("-web.ready.max-silence must be longer than -twitter.poll-interval"))
+		}
+	}
+	if c.shutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("-web.shutdown-timeout must be positive"))
+	}
+	if c.readTimeout < 0 || c.writeTimeout < 0 || c.idleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("-web.read-timeout, -web.write-timeout and -web.idle-timeout must not be negative"))
+	}
+	if c.maxHeaderBytes < 1 {
+		errs = append(errs, fmt.Errorf("-web.max-header-bytes must be positive"))
+	}
+	if c.maxRequests < 0 {
+		errs = append(errs, fmt.Errorf("-web.max-requests must not be negative"))
+	}
+	if c.metrics.expireAfter < 0 {
+		errs = append(errs, fmt.Errorf("-metrics.expire-after must not be negative"))
+	}
+	if c.metrics.maxSeries < 0 {
+		errs = append(errs, fmt.Errorf("-metrics.max-series must not be negative"))
+	}
+	if c.readyMaxSilence < time.Minute {
+		errs = append(errs, fmt.Errorf("-web.ready.max-silence must be at least 1m, as Twitter only sends keep-alives every 30s"))
+	}
+	if c.idleRestartAfter > 0 && c.idleRestartAfter < time.Minute {
+		errs = append(errs, fmt.Errorf("-twitter.idle-restart-after must be at least 1m, as Twitter only sends keep-alives every 30s"))
+	}
+	if c.recordPath != "" && (c.recordMaxSize <= 0 || c.recordMaxAge <= 0) {
+		errs = append(errs, fmt.Errorf("-record.max-size and -record.max-age must be positive"))
+	}
+	if c.metrics.namespace != "" && !model.IsValidMetricName(model.LabelValue(c.metrics.namespace)) {
+		errs = append(errs, fmt.Errorf("Invalid metric namespace %q", c.metrics.namespace))
+	}
+	for name := range c.metrics.constLabels {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			errs = append(errs, fmt.Errorf("Invalid constant label name %q", name))
+		}
+		for _, l := range variableLabels {
+			if name == l {
+				errs = append(errs, fmt.Errorf("Constant label %q conflicts with a label set by the exporter", name))
+			}
+		}
+	}
+	return errs
+}
+
+// validate returns every problem with the stream configuration alone. It
+// is checked again when keywords are changed through the API.
+func (c twitterConfig) validate() []error {
+	var errs []error
+	// Keywords are only sent to Twitter in filter mode.
+	filter := c.mode != "sample"
+	switch c.mode {
+	case "filter", "sample":
+	case "poll":
+		// The search API's limits are handled by splitting the keywords
+		// into several queries.
+		filter = false
+		if c.source != "twitter" {
+			errs = append(errs, fmt.Errorf("Poll mode is only supported with -source=twitter"))
+		}
+		if len(c.follow) > 0 || c.listID != "" || len(c.locations) > 0 {
+			errs = append(errs, fmt.Errorf("Followed users, lists and locations can't be polled"))
+		}
+		if c.pollInterval < 5*time.Second {
+			errs = append(errs, fmt.Errorf("-twitter.poll-interval must be at least 5s"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("Unknown stream mode %q, must be filter, sample or poll", c.mode))
+	}
+	switch c.source {
+	case "twitter":
+	case "mastodon":
+		errs = append(errs, c.validateMastodon()...)
+		// Twitter's limits don't apply.
+		filter = false
+	case "bluesky":
+		if len(c.follow) > 0 || len(c.locations) > 0 {
+			errs = append(errs, fmt.Errorf("Followed users and locations can't be streamed from Bluesky"))
+		}
+		filter = false
+	case "file":
+		if c.sourceFile == "" {
+			errs = append(errs, fmt.Errorf("-source.file must be set to replay tweets from a file"))
+		}
+		if c.sourceRate < 0 {
+			errs = append(errs, fmt.Errorf("-source.rate must not be negative"))
+		}
+		filter = false
+	default:
+		if _, err := newTweetSource(c.source); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	terms := filterTerms(c.track)
+	if len(terms) == 0 && len(c.follow) == 0 && c.listID == "" && len(c.locations) == 0 && c.mode != "sample" {
+		errs = append(errs, fmt.Errorf("At least one keyword, followed user or location must be provided to -twitter.track, -twitter.track-file, -twitter.follow, -twitter.list-id, -twitter.locations or in the config file"))
+	}
+	if c.listID != "" {
+		if _, err := strconv.ParseUint(c.listID, 10, 64); err != nil {
+			errs = append(errs, fmt.Errorf("List %q is not a numeric list ID", c.listID))
+		}
+		if c.source != "twitter" {
+			errs = append(errs, fmt.Errorf("-twitter.list-id is only supported with -source=twitter"))
+		}
+	}
+	if len(c.locations) > maxLocations && filter {
+		errs = append(errs, fmt.Errorf("%d locations are tracked but Twitter allows at most %d", len(c.locations), maxLocations))
+	}
+	if len(c.follow) > maxFollowUsers && filter {
+		errs = append(errs, fmt.Errorf("%d users are followed but Twitter allows at most %d", len(c.follow), maxFollowUsers))
+	}
+	for _, id := range c.follow {
+		if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+			errs = append(errs, fmt.Errorf("Followed user %q is not a numeric user ID", id))
+		}
+	}
+	if len(terms) > maxTrackKeywords && filter {
+		errs = append(errs, fmt.Errorf("%d keywords are tracked but Twitter allows at most %d", len(terms), maxTrackKeywords))
+	}
+	caseSensitive := map[string]bool{}
+	for _, o := range c.keywords {
+		caseSensitive[strings.ToLower(o.Keyword)] = o.CaseSensitive
+	}
+	for _, k := range c.track {
+		if isPattern(k) {
+			if _, err := compilePattern(k, caseSensitive[strings.ToLower(k)]); err != nil {
+				errs = append(errs, fmt.Errorf("Invalid regular expression %q: %v", k, err))
+			}
+			continue
+		}
+		if len(k) > maxKeywordBytes && filter {
+			errs = append(errs, fmt.Errorf("Keyword %q is %d bytes long but Twitter allows at most %d", k, len(k), maxKeywordBytes))
+		}
+	}
+	grouped := map[string]string{}
+	for g, l := range c.groups {
+		for _, k := range l {
+			lk := strings.ToLower(k)
+			if other, ok := grouped[lk]; ok && other != g {
+				errs = append(errs, fmt.Errorf("Keyword %q appears in more than one group", k))
+			}
+			grouped[lk] = g
+		}
+	}
+	for i, o := range c.keywords {
+		if o.Keyword == "" {
+			errs = append(errs, fmt.Errorf("Keyword options %d have no keyword", i+1))
+		}
+		if o.Match != "" && o.Match != "word" && o.Match != "substring" {
+			errs = append(errs, fmt.Errorf("Keyword %q has unknown match type %q, must be word or substring", o.Keyword, o.Match))
+		}
+	}
+	aliased := map[string]string{}
+	for canonical, l := range c.aliases {
+		lc := strings.ToLower(canonical)
+		for _, k := range l {
+			lk := strings.ToLower(k)
+			if other, ok := aliased[lk]; ok && other != lc {
+				errs = append(errs, fmt.Errorf("Alias %q refers to more than one keyword", k))
+			}
+			aliased[lk] = lc
+			if g, ok := grouped[lk]; ok && g != grouped[lc] {
+				errs = append(errs, fmt.Errorf("Alias %q is in group %q but %q is not", k, g, canonical))
+			}
+		}
+	}
+	if c.hashtagPairs && c.hashtagPairsMax < 1 {
+		errs = append(errs, fmt.Errorf("-twitter.hashtag-pairs.max must be at least 1"))
+	}
+	if c.linkDomains && c.linkDomainsMax < 1 {
+		errs = append(errs, fmt.Errorf("-twitter.link-domains.max must be at least 1"))
+	}
+	if c.clientApps && c.clientAppsMax < 1 {
+		errs = append(errs, fmt.Errorf("-twitter.client-apps.max must be at least 1"))
+	}
+	if c.emoji && c.emojiMax < 1 {
+		errs = append(errs, fmt.Errorf("-twitter.emoji.max must be at least 1"))
+	}
+	if c.botHeuristics && (c.botMinSignals < 1 || c.botMinSignals > 4) {
+		errs = append(errs, fmt.Errorf("-twitter.bot-heuristics.min-signals must be between 1 and 4"))
+	}
+	if c.duplicates && c.duplicatesWindow <= 0 {
+		errs = append(errs, fmt.Errorf("-twitter.duplicates.window must be positive"))
+	}
+	if c.trendingHashtags < 0 || c.trendingHashtags > 1000 {
+		errs = append(errs, fmt.Errorf("-twitter.trending-hashtags must be between 0 and 1000"))
+	}
+	if c.trendingHashtags > 0 && c.trendingHalfLife < time.Minute {
+		errs = append(errs, fmt.Errorf("-twitter.trending-hashtags.half-life must be at least 1m"))
+	}
+	if c.logSampleMatches < 0 {
+		errs = append(errs, fmt.Errorf("-log.sample-matches must not be negative"))
+	}
+	if c.sampleRate <= 0 || c.sampleRate > 1 {
+		errs = append(errs, fmt.Errorf("-twitter.sample-rate must be greater than 0 and at most 1"))
+	}
+	if c.classifierURL != "" {
+		if u, err := url.Parse(c.classifierURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("-classifier.url must be an http or https URL"))
+		}
+		if c.classifierTimeout <= 0 || c.classifierConcurrency < 1 || c.classifierMaxLabels < 1 {
+			errs = append(errs, fmt.Errorf("-classifier.timeout, -classifier.concurrency and -classifier.max-labels must be positive"))
+		}
+	}
+	return append(errs, c.validateCredentials()...)
+}
+
+// validateMastodon returns every problem with streaming from Mastodon, which
+// can only filter statuses by hashtag.
+func (c twitterConfig) validateMastodon() []error {
+	var errs []error
+	if u, err := url.Parse(c.mastodonURL); c.mastodonURL == "" || err != nil || u.Host == "" {
+		errs = append(errs, fmt.Errorf("-mastodon.url must be set to the base URL of a Mastodon instance"))
+	}
+	if len(c.follow) > 0 || len(c.locations) > 0 {
+		errs = append(errs, fmt.Errorf("Followed users and locations can't be streamed from Mastodon"))
+	}
+	if c.mode != "filter" {
+		return errs
+	}
+	for _, k := range filterTerms(c.track) {
+		if !hashtagPattern.MatchString(k) {
+			errs = append(errs, fmt.Errorf("Keyword %q can't be streamed from Mastodon, which only streams single hashtags", k))
+		}
+	}
+	return errs
+}
+
+// variableLabels are the label names used by exported metrics, which can't
+// also be used as constant labels.
+var variableLabels = []string{
+	"keyword", "group", "retweet", "quoted", "lang", "user", "box", "to_user", "reason", "code", "other_hashtag", "hashtag", "domain", "type", "app", "country_code", "place_type", "sentiment", "label", "emoji", "match_type", "count", "window", "field", "metric",
+	"version", "commit_sha", "build_date", "golang_version",
+}
+
+// validateCredentials returns an error for each missing credential for the
+// selected source.
+// A bearer token replaces the four oauth1 values.
+func (c twitterConfig) validateCredentials() []error {
+	switch c.source {
+	case "file":
+		return nil
+	case "mastodon":
+		if c.mastodonToken == "" {
+			return []error{fmt.Errorf("No Mastodon access token provided, please set %s or %s_FILE", envMastodonToken, envMastodonToken)}
+		}
+		return nil
+	case "bluesky":
+		var errs []error
+		if c.blueskyIdentifier == "" {
+			errs = append(errs, fmt.Errorf("No Bluesky handle provided, please set %s or %s_FILE", envBlueskyID, envBlueskyID))
+		}
+		if c.blueskyPassword == "" {
+			errs = append(errs, fmt.Errorf("No Bluesky app password provided, please set %s or %s_FILE", envBlueskyPass, envBlueskyPass))
+		}
+		return errs
+	}
+	if c.bearerToken != "" {
+		return nil
+	}
+	var errs []error
+	if c.accessToken == "" {
+		errs = append(errs, fmt.Errorf("No Twitter access token provided, please set %s or %s_FILE", envAccessToken, envAccessToken))
+	}
+	if c.tokenSecret == "" {
+		errs = append(errs, fmt.Errorf("No Twitter access token secret provided, please set %s or %s_FILE", envAccessSecret, envAccessSecret))
+	}
+	if c.consumerKey == "" {
+		errs = append(errs, fmt.Errorf("No Twitter consumer key provided, please set %s or %s_FILE", envConsumerKey, envConsumerKey))
+	}
+	if c.consumerSecret == "" {
+		errs = append(errs, fmt.Errorf("No Twitter consumer secret provided, please set %s or %s_FILE", envConsumerSecret, envConsumerSecret))
+	}
+	if len(errs) == 4 {
+		errs = append(errs, fmt.Errorf("Alternatively, set %s or %s_FILE to use app-only authentication", envBearerToken, envBearerToken))
+	}
+	return errs
+}
+
+// lintKeywords returns problems with a keyword list which don't stop the
+// exporter from running but probably indicate a mistake.
+func lintKeywords(track []string) []error {
+	var errs []error
+	seen := map[string]bool{}
+	for i, k := range track {
+		if strings.TrimSpace(k) == "" {
+			errs = append(errs, fmt.Errorf("Keyword %d is empty", i+1))
+			continue
+		}
+		lk := strings.ToLower(k)
+		if seen[lk] {
+			errs = append(errs, fmt.Errorf("Keyword %q is listed more than once (keywords are case-insensitive)", k))
+		}
+		seen[lk] = true
+	}
+	return errs
+}
+
+// checkConfig reports every problem with the configuration without opening a
+// stream, returning the process's exit code.
+func checkConfig() int {
+	c, err := buildConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	errs := append(c.validate(), lintKeywords(c.twitter.track)...)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Printf("Configuration is valid, tracking %d keywords\n", len(c.twitter.track))
+	return 0
+}
+
+// credential returns the value of the environment variable name. If that
+// isn't set but name_FILE is, the value is read from the file it points to,
+// allowing secrets to be mounted rather than passed through the environment.
+// Failing both, fileValue from the config file is returned.
+func credential(name, fileValue string) (string, error) {
+	if v := os.Getenv(name); v != "" {
+		return v, nil
+	}
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("error reading %s_FILE: %v", name, err)
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return fileValue, nil
+}
+
+// containsFold reports whether l contains s, ignoring case.
+func containsFold(l []string, s string) bool {
+	for _, v := range l {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLabels parses a comma-separated list of name=value pairs.
+func parseLabels(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range splitList(s) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid label %q, must be name=value", pair)
+		}
+		labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return labels, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
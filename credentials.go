@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// credentialSource fetches Twitter credentials from an external secret store.
+type credentialSource interface {
+	// fetch returns the key/value pairs stored in the secret, along with its
+	// version if the store tracks one.
+	fetch() (map[string]string, string, error)
+}
+
+// newCredentialSource returns the credential source selected by c, or nil if
+// credentials only come from the environment and config file.
+func newCredentialSource(c *config) (credentialSource, error) {
+	switch c.credentialSource {
+	case "", "env":
+		return nil, nil
+	case "vault":
+		return newVaultClient(c.vaultAddress, c.vaultPath)
+	case "aws-secretsmanager":
+		return newAWSSecretSource("secretsmanager", c.credentialSecretID)
+	case "aws-ssm":
+		return newAWSSecretSource("ssm", c.credentialSecretID)
+	}
+	return nil, fmt.Errorf("Unknown credential source %q, must be one of env, vault, aws-secretsmanager or aws-ssm", c.credentialSource)
+}
+
+// watchCredentials periodically re-reads the secret from src, calling
+// onChange whenever its version or contents differ from the previous read.
+// Sources which hold a lease are renewed on the same schedule. It never
+// returns.
+func watchCredentials(src credentialSource, interval time.Duration, onChange func()) {
+	last, lastVersion, err := src.fetch()
+	if err != nil {
+		logError("Error reading credentials", "err", err)
+	}
+	for range time.Tick(interval) {
+		if r, ok := src.(interface {
+			renew() error
+		}); ok {
+			if err := r.renew(); err != nil {
+				logError("Error renewing credential lease", "err", err)
+			}
+		}
+		secret, version, err := src.fetch()
+		if err != nil {
+			logError("Error reading credentials", "err", err)
+			continue
+		}
+		if version == lastVersion && reflect.DeepEqual(secret, last) {
+			continue
+		}
+		last, lastVersion = secret, version
+		logInfo("Twitter credentials have changed")
+		onChange()
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/dghubble/go-twitter/twitter"
+	"golang.org/x/net/publicsuffix"
+)
+
+// linkDomain returns the registered domain of the link in u, such as
+// "bbc.co.uk" for "https://www.bbc.co.uk/news", or false if it has none.
+func linkDomain(u twitter.URLEntity) (string, bool) {
+	raw := u.ExpandedURL
+	if raw == "" {
+		raw = u.URL
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	host := strings.TrimSuffix(strings.ToLower(parsed.Hostname()), ".")
+	if host == "" {
+		return "", false
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return "", false
+	}
+	return domain, true
+}
+
+// urlKeywords returns the keywords which appear in the expanded or display
+// URL of u, each once. URLs are split into words at punctuation, so
+// "example.com/acme-sale" matches both "acme" and "acme sale".
+func urlKeywords(m *matcher, u twitter.URLEntity) []keyword {
+	var found []keyword
+	seen := map[string]bool{}
+	for _, raw := range []string{u.ExpandedURL, u.DisplayURL} {
+		words := strings.FieldsFunc(raw, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		m.text(strings.Join(words, " "), func(kw keyword) {
+			if !seen[kw.label] {
+				seen[kw.label] = true
+				found = append(found, kw)
+			}
+		})
+	}
+	return found
+}
+
+// countLinkDomains counts the registered domain of each link in s once,
+// limited by l.
+func (e *Exporter) countLinkDomains(l *labelLimit, s *twitter.Tweet, rt string) {
+	if s.Entities == nil {
+		return
+	}
+	counted := map[string]bool{}
+	for _, u := range s.Entities.Urls {
+		domain, ok := linkDomain(u)
+		if !ok {
+			continue
+		}
+		label, ok := l.label(domain)
+		if !ok || counted[label] {
+			continue
+		}
+		counted[label] = true
+		e.linkDomains.WithLabelValues(e.series.values("twitter_stream_link_domains_total", label, rt)...).Inc()
+	}
+}
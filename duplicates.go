@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math/bits"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxFingerprints is the number of recent tweets whose fingerprints are
+	// kept, however short the window.
+	maxFingerprints = 10000
+	// duplicateDistance is the largest number of bits in which the
+	// fingerprints of near-duplicate texts differ. Tweets have too few words
+	// for the 3 bits usual with web pages: changing one word in twenty flips
+	// about 5 bits, while unrelated texts differ in about 32.
+	duplicateDistance = 8
+)
+
+// fingerprint is the simhash of a recent tweet's text.
+type fingerprint struct {
+	hash uint64
+	at   time.Time
+}
+
+// duplicateFilter detects tweets whose text is the same or nearly the same
+// as another tweet's within a short window, as in copy-and-paste spam.
+type duplicateFilter struct {
+	window  time.Duration
+	exclude bool
+
+	mtx          sync.Mutex
+	fingerprints [maxFingerprints]fingerprint
+	next         int
+}
+
+func newDuplicateFilter(window time.Duration, exclude bool) *duplicateFilter {
+	return &duplicateFilter{window: window, exclude: exclude}
+}
+
+// simhash returns a fingerprint of the words of text which differs in only
+// a few bits for texts which share most of their words. Links and mentions
+// are ignored, as spam often varies them.
+// https://www.cs.princeton.edu/courses/archive/spr04/cos598B/bib/CharikarEstim.pdf
+func simhash(text string) (uint64, bool) {
+	var weights [64]int
+	n := 0
+	for _, w := range tokenize(text) {
+		w = strings.ToLower(w)
+		if strings.HasPrefix(w, "http") || strings.HasPrefix(w, "@") || w == "rt" {
+			continue
+		}
+		n++
+		h := hashValue(w)
+		for i := range weights {
+			if h&(1<<uint(i)) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+	var hash uint64
+	for i, w := range weights {
+		if w > 0 {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash, n > 0
+}
+
+// duplicate reports whether text duplicates a tweet seen within the window,
+// and remembers it for comparison with later tweets.
+func (d *duplicateFilter) duplicate(text string) bool {
+	hash, ok := simhash(text)
+	if !ok {
+		return false
+	}
+	now := time.Now()
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	dup := false
+	for _, f := range d.fingerprints {
+		if !f.at.IsZero() && now.Sub(f.at) <= d.window && bits.OnesCount64(f.hash^hash) <= duplicateDistance {
+			dup = true
+			break
+		}
+	}
+	d.fingerprints[d.next] = fingerprint{hash: hash, at: now}
+	d.next = (d.next + 1) % maxFingerprints
+	return dup
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math/bits"
+	"testing"
+	"time"
+)
+
+const spamText = "Win a brand new phone today, just follow and retweet this post before midnight to enter the giveaway, winners picked at random tomorrow morning"
+
+func TestSimhash(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		// The fingerprints differ in at least min and at most max bits.
+		min, max int
+	}{
+		{spamText, spamText, 0, 0},
+		// Case, links, mentions and retweets are ignored.
+		{spamText, "RT @spammer WIN a brand new phone today, just follow and retweet this post before midnight to enter the giveaway, winners picked at random tomorrow morning https://t.co/abc", 0, 0},
+		// One word in twenty-four changed.
+		{spamText, "Win a brand new laptop today, just follow and retweet this post before midnight to enter the giveaway, winners picked at random tomorrow morning", 0, duplicateDistance},
+		// Unrelated texts differ in about half of their bits.
+		{spamText, "The conference talks were recorded and should be online by the end of next week, along with the slides", 16, 48},
+	} {
+		ha, _ := simhash(tt.a)
+		hb, _ := simhash(tt.b)
+		if d := bits.OnesCount64(ha ^ hb); d < tt.min || d > tt.max {
+			t.Errorf("%q and %q: fingerprints differ in %d bits, want %d to %d", tt.a, tt.b, d, tt.min, tt.max)
+		}
+	}
+
+	for _, text := range []string{"", "   ", "https://t.co/abc @spammer RT"} {
+		if _, ok := simhash(text); ok {
+			t.Errorf("%q: got a fingerprint, want none", text)
+		}
+	}
+}
+
+func TestDuplicateFilter(t *testing.T) {
+	d := newDuplicateFilter(time.Minute, true)
+	for _, tt := range []struct {
+		text string
+		want bool
+	}{
+		{spamText, false},
+		{spamText, true},
+		{"Win a brand new laptop today, just follow and retweet this post before midnight to enter the giveaway, winners picked at random tomorrow morning", true},
+		{"The conference talks were recorded and should be online by the end of next week, along with the slides", false},
+		// Tweets without words are never duplicates.
+		{"@spammer", false},
+		{"@spammer", false},
+	} {
+		if got := d.duplicate(tt.text); got != tt.want {
+			t.Errorf("%q: got duplicate %v, want %v", tt.text, got, tt.want)
+		}
+	}
+
+	// Fingerprints older than the window are forgotten.
+	for i := range d.fingerprints {
+		if !d.fingerprints[i].at.IsZero() {
+			d.fingerprints[i].at = d.fingerprints[i].at.Add(-2 * time.Minute)
+		}
+	}
+	if d.duplicate(spamText) {
+		t.Errorf("%q after the window: got duplicate true, want false", spamText)
+	}
+}
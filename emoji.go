@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+const (
+	zeroWidthJoiner   = '\u200d'
+	variationSelector = '\ufe0f'
+)
+
+// isEmoji reports whether r can start an emoji. This covers the blocks
+// emoji are allocated in rather than the exact list, which changes with
+// every Unicode release.
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1f000 && r <= 0x1faff:
+		return !isSkinTone(r)
+	case r >= 0x2600 && r <= 0x27bf, r >= 0x2b00 && r <= 0x2bff:
+		return true
+	case r >= 0x2300 && r <= 0x23ff, r == 0x203c, r == 0x2049, r == 0x2122, r == 0x2139, r >= 0x2194 && r <= 0x21aa:
+		return true
+	}
+	return false
+}
+
+// isSkinTone reports whether r is a Fitzpatrick modifier, which changes the
+// skin tone of the emoji before it.
+func isSkinTone(r rune) bool {
+	return r >= 0x1f3fb && r <= 0x1f3ff
+}
+
+// isRegionalIndicator reports whether r is one of the letters which form
+// flags in pairs.
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// emojis returns the emoji in text, keeping sequences such as flags,
+// skin tones and family groups joined with zero-width joiners together.
+// Variation selectors are dropped so that the text and emoji presentations
+// of a character are counted as one.
+func emojis(text string) []string {
+	var found []string
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		if !isEmoji(runes[i]) {
+			continue
+		}
+		var b strings.Builder
+		b.WriteRune(runes[i])
+		if isRegionalIndicator(runes[i]) {
+			if i+1 < len(runes) && isRegionalIndicator(runes[i+1]) {
+				i++
+				b.WriteRune(runes[i])
+			}
+			found = append(found, b.String())
+			continue
+		}
+	sequence:
+		for i+1 < len(runes) {
+			r := runes[i+1]
+			switch {
+			case r == variationSelector:
+				i++
+			case isSkinTone(r):
+				i++
+				b.WriteRune(r)
+			case r == zeroWidthJoiner && i+2 < len(runes) && isEmoji(runes[i+2]):
+				i += 2
+				b.WriteRune(r)
+				b.WriteRune(runes[i])
+			default:
+				break sequence
+			}
+		}
+		found = append(found, b.String())
+	}
+	return found
+}
+
+// emojiLimit returns the limit on the emoji label in c. Variation selectors
+// are dropped from allowed emoji, as they are from those found in tweets.
+func emojiLimit(c twitterConfig) (int, []string) {
+	var allow []string
+	for _, em := range c.emojiAllow {
+		allow = append(allow, strings.Replace(em, string(variationSelector), "", -1))
+	}
+	return c.emojiMax, allow
+}
+
+// countEmoji counts each distinct emoji in s once, limited by l.
+func (e *Exporter) countEmoji(l *labelLimit, s *twitter.Tweet, rt string) {
+	counted := map[string]bool{}
+	for _, em := range emojis(s.Text) {
+		label, ok := l.label(em)
+		if !ok || counted[label] {
+			continue
+		}
+		counted[label] = true
+		e.emoji.WithLabelValues(e.series.values("twitter_stream_emoji_total", label, rt)...).Inc()
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// minExpirySweep is the shortest interval between sweeps for expired series.
+const minExpirySweep = time.Second
+
+// labelExpirer removes series from metric vectors once they've gone
+// unchanged for longer than expireAfter, so that keywords and hashtags which
+// stop appearing don't accumulate forever. Metric vectors don't record when
+// a series was last updated, so each sweep compares the series' values with
+// those from the previous sweep.
+type labelExpirer struct {
+	mtx         sync.Mutex
+	vecs        []*prometheus.MetricVec
+	constLabels map[string]string
+	expireAfter time.Duration
+	// seen holds the value of each series and when it last changed, keyed
+	// by vector index and label values.
+	seen map[string]seenSeries
+}
+
+type seenSeries struct {
+	value   float64
+	changed time.Time
+}
+
+func newLabelExpirer(expireAfter time.Duration, constLabels map[string]string, vecs ...*prometheus.MetricVec) *labelExpirer {
+	return &labelExpirer{
+		vecs:        vecs,
+		constLabels: constLabels,
+		expireAfter: expireAfter,
+		seen:        map[string]seenSeries{},
+	}
+}
+
+// run sweeps for expired series until the process exits.
+func (x *labelExpirer) run() {
+	interval := x.expireAfter / 10
+	if interval < minExpirySweep {
+		interval = minExpirySweep
+	}
+	for range time.Tick(interval) {
+		if n := x.sweep(time.Now()); n > 0 {
+			logDebug("Expired idle metric series", "count", n)
+		}
+	}
+}
+
+// sweep removes series which haven't changed since before now-expireAfter
+// and returns how many were removed.
+func (x *labelExpirer) sweep(now time.Time) int {
+	x.mtx.Lock()
+	defer x.mtx.Unlock()
+
+	current := map[string]bool{}
+	expired := 0
+	for i, vec := range x.vecs {
+		ch := make(chan prometheus.Metric)
+		go func(vec *prometheus.MetricVec) {
+			vec.Collect(ch)
+			close(ch)
+		}(vec)
+		var stale []prometheus.Labels
+		for m := range ch {
+			var pb dto.Metric
+			if err := m.Write(&pb); err != nil {
+				continue
+			}
+			labels := prometheus.Labels{}
+			values := []string{strconv.Itoa(i)}
+			for _, lp := range pb.Label {
+				if _, ok := x.constLabels[lp.GetName()]; ok {
+					continue
+				}
+				labels[lp.GetName()] = lp.GetValue()
+				values = append(values, lp.GetName()+"="+lp.GetValue())
+			}
+			key := strings.Join(values, "\xff")
+			current[key] = true
+
+			value := seriesValue(&pb)
+			s, ok := x.seen[key]
+			if !ok || s.value != value {
+				x.seen[key] = seenSeries{value: value, changed: now}
+				continue
+			}
+			if now.Sub(s.changed) > x.expireAfter {
+				stale = append(stale, labels)
+			}
+		}
+		// Deleting while collecting would deadlock on the vector's lock.
+		for _, labels := range stale {
+			if vec.Delete(labels) {
+				expired++
+			}
+		}
+	}
+	for key := range x.seen {
+		if !current[key] {
+			delete(x.seen, key)
+		}
+	}
+	return expired
+}
+
+// seriesValue returns a value which changes whenever the series is updated.
+func seriesValue(m *dto.Metric) float64 {
+	switch {
+	case m.Counter != nil:
+		return m.Counter.GetValue()
+	case m.Histogram != nil:
+		return float64(m.Histogram.GetSampleCount())
+	case m.Gauge != nil:
+		return m.Gauge.GetValue()
+	}
+	return 0
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// streamHost is the host serving the public streaming API.
+const streamHost = "stream.twitter.com"
+
+// searchPath is the path of the standard search API used in poll mode.
+const searchPath = "/1.1/search/tweets.json"
+
+// extendedTweetTransport rewrites tweets delivered by the streaming and
+// search APIs so that those longer than 140 characters carry their full text
+// and entities. The vendored client predates extended tweets and would
+// otherwise only see the truncated text.
+type extendedTweetTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *extendedTweetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	search := req.URL.Path == searchPath
+	if search {
+		// The search API only returns full_text when asked to.
+		r := new(http.Request)
+		*r = *req
+		u := *req.URL
+		q := u.Query()
+		q.Set("tweet_mode", "extended")
+		u.RawQuery = q.Encode()
+		r.URL = &u
+		req = r
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	switch {
+	case req.URL.Host == streamHost:
+		resp.Body = &extendedTweetReader{body: resp.Body, r: bufio.NewReader(resp.Body)}
+	case search:
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		b = expandSearchResults(b)
+		resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+		resp.ContentLength = int64(len(b))
+		resp.Header.Del("Content-Length")
+	}
+	return resp, nil
+}
+
+// extendedTweetReader expands each message in a stream response body.
+type extendedTweetReader struct {
+	body    io.ReadCloser
+	r       *bufio.Reader
+	pending []byte
+	err     error
+}
+
+// Read implements io.Reader.
+func (r *extendedTweetReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		// Messages are separated by "\r\n", and newlines within tweets are
+		// always escaped.
+		var line []byte
+		line, r.err = r.r.ReadBytes('\n')
+		msg := bytes.TrimRight(line, "\r\n")
+		if len(msg) == 0 {
+			r.pending = line
+			continue
+		}
+		r.pending = append(expandTweet(msg), line[len(msg):]...)
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Close implements io.Closer.
+func (r *extendedTweetReader) Close() error {
+	return r.body.Close()
+}
+
+// expandTweet replaces the text and entities of a truncated tweet, and of
+// any tweet it retweets or quotes, with those of the full tweet. Other
+// messages are returned unchanged.
+func expandTweet(msg []byte) []byte {
+	if !bytes.Contains(msg, []byte(`"extended_tweet"`)) {
+		return msg
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return msg
+	}
+	if ext, ok := m["extended_tweet"]; ok {
+		var x struct {
+			FullText         string          `json:"full_text"`
+			Entities         json.RawMessage `json:"entities"`
+			ExtendedEntities json.RawMessage `json:"extended_entities"`
+		}
+		if err := json.Unmarshal(ext, &x); err == nil && x.FullText != "" {
+			m["text"], _ = json.Marshal(x.FullText)
+			m["truncated"] = json.RawMessage("false")
+			if len(x.Entities) > 0 {
+				m["entities"] = x.Entities
+			}
+			if len(x.ExtendedEntities) > 0 {
+				m["extended_entities"] = x.ExtendedEntities
+			}
+			delete(m, "extended_tweet")
+		}
+	}
+	for _, k := range []string{"retweeted_status", "quoted_status"} {
+		if s, ok := m[k]; ok {
+			m[k] = expandTweet(s)
+		}
+	}
+	out, err := json.Marshal(m)
+	if err != nil {
+		return msg
+	}
+	return out
+}
+
+// expandSearchResults moves the full_text of each tweet in a search response
+// requested with tweet_mode=extended to text, where the vendored client
+// expects it.
+func expandSearchResults(body []byte) []byte {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		return body
+	}
+	var statuses []json.RawMessage
+	if err := json.Unmarshal(m["statuses"], &statuses); err != nil {
+		return body
+	}
+	for i, s := range statuses {
+		statuses[i] = useFullText(s)
+	}
+	m["statuses"], _ = json.Marshal(statuses)
+	out, err := json.Marshal(m)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// useFullText replaces the text of an extended mode tweet, and of any tweet
+// it retweets or quotes, with its full_text.
+func useFullText(tweet []byte) []byte {
+	if !bytes.Contains(tweet, []byte(`"full_text"`)) {
+		return tweet
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(tweet, &m); err != nil {
+		return tweet
+	}
+	if ft, ok := m["full_text"]; ok {
+		m["text"] = ft
+		delete(m, "full_text")
+	}
+	for _, k := range []string{"retweeted_status", "quoted_status"} {
+		if s, ok := m[k]; ok {
+			m[k] = useFullText(s)
+		}
+	}
+	out, err := json.Marshal(m)
+	if err != nil {
+		return tweet
+	}
+	return out
+}
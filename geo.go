@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// maxLocations is the number of bounding boxes Twitter allows in a filter.
+// https://dev.twitter.com/streaming/overview/request-parameters#locations
+const maxLocations = 25
+
+// geoBox is a bounding box given by its south-west and north-east corners.
+type geoBox struct {
+	name                       string
+	swLon, swLat, neLon, neLat float64
+}
+
+// newGeoBox returns a box from the south-west longitude and latitude
+// followed by the north-east longitude and latitude.
+func newGeoBox(name string, coords []float64) (geoBox, error) {
+	if len(coords) != 4 {
+		return geoBox{}, fmt.Errorf("Location %q must have 4 coordinates, not %d", name, len(coords))
+	}
+	b := geoBox{name: name, swLon: coords[0], swLat: coords[1], neLon: coords[2], neLat: coords[3]}
+	if b.swLon < -180 || b.neLon > 180 || b.swLat < -90 || b.neLat > 90 {
+		return geoBox{}, fmt.Errorf("Location %q is outside the range of longitudes and latitudes", name)
+	}
+	if b.swLon >= b.neLon || b.swLat >= b.neLat {
+		return geoBox{}, fmt.Errorf("Location %q must give the south-west corner before the north-east corner", name)
+	}
+	return b, nil
+}
+
+// parseLocations parses a comma-separated list of bounding boxes, each of
+// which is named after its coordinates.
+func parseLocations(s string) ([]geoBox, error) {
+	l := splitList(s)
+	if len(l)%4 != 0 {
+		return nil, fmt.Errorf("Locations must be given as groups of 4 coordinates, not %d", len(l))
+	}
+	var boxes []geoBox
+	for i := 0; i < len(l); i += 4 {
+		coords := make([]float64, 4)
+		for j := range coords {
+			v, err := strconv.ParseFloat(strings.TrimSpace(l[i+j]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid coordinate %q: %v", l[i+j], err)
+			}
+			coords[j] = v
+		}
+		b, err := newGeoBox(strings.Join(l[i:i+4], ","), coords)
+		if err != nil {
+			return nil, err
+		}
+		boxes = append(boxes, b)
+	}
+	return boxes, nil
+}
+
+// locationParams returns boxes in the form expected by the filter stream.
+func locationParams(boxes []geoBox) []string {
+	var p []string
+	for _, b := range boxes {
+		for _, v := range []float64{b.swLon, b.swLat, b.neLon, b.neLat} {
+			p = append(p, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+	return p
+}
+
+// contains reports whether a tweet was posted within the box. As with
+// Twitter's filter, the tweet's exact coordinates are used if it has them,
+// and otherwise its place is matched if the two boxes overlap.
+func (b geoBox) contains(t *twitter.Tweet) bool {
+	if t.Coordinates != nil {
+		lon, lat := t.Coordinates.Coordinates[0], t.Coordinates.Coordinates[1]
+		return lon >= b.swLon && lon <= b.neLon && lat >= b.swLat && lat <= b.neLat
+	}
+	if t.Place == nil || t.Place.BoundingBox == nil {
+		return false
+	}
+	for _, ring := range t.Place.BoundingBox.Coordinates {
+		if len(ring) == 0 {
+			continue
+		}
+		minLon, minLat, maxLon, maxLat := ring[0][0], ring[0][1], ring[0][0], ring[0][1]
+		for _, p := range ring[1:] {
+			if p[0] < minLon {
+				minLon = p[0]
+			}
+			if p[0] > maxLon {
+				maxLon = p[0]
+			}
+			if p[1] < minLat {
+				minLat = p[1]
+			}
+			if p[1] > maxLat {
+				maxLat = p[1]
+			}
+		}
+		if minLon <= b.neLon && maxLon >= b.swLon && minLat <= b.neLat && maxLat >= b.swLat {
+			return true
+		}
+	}
+	return false
+}
+
+// orUnknown returns s, or "unknown" if it's empty, for labelling places
+// which are missing details.
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// healthyHandler reports that the exporter is running and serving HTTP.
+func healthyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "OK")
+	})
+}
+
+// readyHandler reports whether the stream is connected and has received
+// data, including keep-alives, within maxSilence, responding with 503
+// Service Unavailable if it isn't.
+func readyHandler(e *Exporter, maxSilence time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := e.ready(maxSilence); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	})
+}
+
+// ready returns an error describing why the stream isn't healthy, if it
+// isn't connected or hasn't received data within maxSilence.
+func (e *Exporter) ready(maxSilence time.Duration) error {
+	if atomic.LoadInt32(&e.isPaused) == 1 {
+		return errStreamPaused
+	}
+	if atomic.LoadInt32(&e.isConnected) != 1 {
+		return fmt.Errorf("stream is not connected")
+	}
+	if silence := time.Since(time.Unix(0, atomic.LoadInt64(&e.lastActivity))); silence > maxSilence {
+		return fmt.Errorf("no data received from the stream for %s", silence.Round(time.Second))
+	}
+	return nil
+}
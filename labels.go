@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// otherLabel is the label value given to values beyond a labelLimit's cap.
+const otherLabel = "__other__"
+
+// labelLimit bounds the values of a label taken from the contents of tweets.
+// Values in deny are never counted, and if allow is given only its values
+// are. Otherwise at most max distinct values are given their own label.
+type labelLimit struct {
+	max   int
+	allow map[string]bool
+	deny  map[string]bool
+
+	mtx  sync.Mutex
+	seen map[string]bool
+}
+
+// newLabelLimit returns a labelLimit with the given limits. Values are
+// compared case-insensitively.
+func newLabelLimit(max int, allow, deny []string) *labelLimit {
+	return &labelLimit{max: max, allow: lowerSet(allow), deny: lowerSet(deny), seen: map[string]bool{}}
+}
+
+// lowerSet returns the lowercased values in l as a set, or nil if l is empty.
+func lowerSet(l []string) map[string]bool {
+	if len(l) == 0 {
+		return nil
+	}
+	s := map[string]bool{}
+	for _, v := range l {
+		s[strings.ToLower(v)] = true
+	}
+	return s
+}
+
+// label returns the label for v, or false if it isn't counted. Once max
+// distinct values have been seen, new ones are all labelled __other__.
+func (l *labelLimit) label(v string) (string, bool) {
+	v = strings.ToLower(v)
+	if l.deny[v] {
+		return "", false
+	}
+	if l.allow != nil {
+		return v, l.allow[v]
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if !l.seen[v] {
+		if len(l.seen) >= l.max {
+			return otherLabel, true
+		}
+		l.seen[v] = true
+	}
+	return v, true
+}
+
+// sameLimits reports whether l was created with the given limits, so that it
+// can be kept across reloads.
+func (l *labelLimit) sameLimits(max int, allow, deny []string) bool {
+	return l.max == max && reflect.DeepEqual(l.allow, lowerSet(allow)) && reflect.DeepEqual(l.deny, lowerSet(deny))
+}
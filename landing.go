@@ -0,0 +1,71 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Twitter Stream Exporter</title></head>
+<body>
+<h1>Twitter Stream Exporter</h1>
+<p><a href="{{.MetricsPath}}">Metrics</a></p>
+<h2>Status</h2>
+<table>
+<tr><th align="left">Version</th><td>{{.Version}} ({{.Commit}}, built {{.BuildDate}})</td></tr>
+<tr><th align="left">Stream</th><td>{{if .Paused}}Paused{{else if .Connected}}Connected{{else}}Disconnected{{end}}{{if .NotReady}} &mdash; not ready: {{.NotReady}}{{end}}</td></tr>
+<tr><th align="left">Last tweet</th><td>{{if .LastTweet.IsZero}}None yet{{else}}{{.LastTweet.Format "2006-01-02 15:04:05 MST"}} ({{.SinceLastTweet}} ago){{end}}</td></tr>
+</table>
+<h2>Tracked keywords</h2>
+{{if .Keywords}}<ul>
+{{range .Keywords}}<li>{{.}}</li>
+{{end}}</ul>{{else}}<p>None</p>{{end}}
+</body>
+</html>
+`))
+
+// landingHandler serves a page at / linking to the metrics and showing the
+// exporter's status, like other Prometheus exporters. Other paths are 404s.
+func landingHandler(e *Exporter, metricsPath string, maxSilence time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		data := struct {
+			MetricsPath                string
+			Version, Commit, BuildDate string
+			Connected, Paused          bool
+			NotReady                   string
+			LastTweet                  time.Time
+			SinceLastTweet             time.Duration
+			Keywords                   []string
+		}{
+			MetricsPath: metricsPath,
+			Version:     Version,
+			Commit:      CommitSHA1,
+			BuildDate:   BuildDate,
+			Connected:   atomic.LoadInt32(&e.isConnected) == 1,
+			Paused:      atomic.LoadInt32(&e.isPaused) == 1,
+		}
+		if err := e.ready(maxSilence); err != nil && data.Connected {
+			data.NotReady = err.Error()
+		}
+		if t := atomic.LoadInt64(&e.lastTweet); t != 0 {
+			data.LastTweet = time.Unix(0, t).UTC()
+			data.SinceLastTweet = time.Since(data.LastTweet).Round(time.Second)
+		}
+		for k := range e.Keywords() {
+			data.Keywords = append(data.Keywords, k)
+		}
+		sort.Strings(data.Keywords)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := landingTemplate.Execute(w, data); err != nil {
+			logError("Error rendering landing page", "err", err)
+		}
+	})
+}
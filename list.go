@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// listMembersURL is the endpoint returning the members of a list.
+// https://developer.twitter.com/en/docs/twitter-api/v1/accounts-and-users/create-manage-lists/api-reference/get-lists-members
+const listMembersURL = "https://api.twitter.com/1.1/lists/members.json"
+
+// getListMembers returns the screen names of the members of the list in c,
+// indexed by user ID.
+func getListMembers(c twitterConfig) (map[string]string, error) {
+	hc := getHTTPClient(c)
+	members := map[string]string{}
+	cursor := "-1"
+	for cursor != "0" {
+		q := url.Values{
+			"list_id":          {c.listID},
+			"count":            {"5000"},
+			"cursor":           {cursor},
+			"skip_status":      {"true"},
+			"include_entities": {"false"},
+		}
+		resp, err := hc.Get(listMembersURL + "?" + q.Encode())
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Users      []twitter.User `json:"users"`
+			NextCursor string         `json:"next_cursor_str"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("error getting members of list %s: %s", c.listID, resp.Status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing members of list %s: %v", c.listID, err)
+		}
+		for _, u := range page.Users {
+			members[u.IDStr] = u.ScreenName
+		}
+		cursor = page.NextCursor
+		if cursor == "" {
+			break
+		}
+	}
+	if n := len(members) + len(c.follow); n > maxFollowUsers {
+		return nil, fmt.Errorf("List %s and -twitter.follow give %d users to follow but Twitter allows at most %d", c.listID, n, maxFollowUsers)
+	}
+	return members, nil
+}
+
+// withListMembers returns c with the members of its list also followed and
+// labelled by their screen names.
+func withListMembers(c twitterConfig, members map[string]string) twitterConfig {
+	if len(members) == 0 {
+		return c
+	}
+	followed := map[string]bool{}
+	for _, id := range c.follow {
+		followed[id] = true
+	}
+	ids := make([]string, 0, len(members))
+	for id := range members {
+		if !followed[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	c.follow = append(append([]string{}, c.follow...), ids...)
+	c.followNames = members
+	return c
+}
+
+// watchList re-reads the members of the followed list every interval,
+// restarting the stream when they change. If the restart fails, restart
+// retries it after a backoff. It never returns.
+func (e *Exporter) watchList(interval time.Duration) {
+	for range time.Tick(interval) {
+		e.streamMtx.Lock()
+		c := e.base
+		e.streamMtx.Unlock()
+		if c.listID == "" {
+			continue
+		}
+
+		members, err := getListMembers(c)
+		if err != nil {
+			logError("Error refreshing list members", "err", err)
+			continue
+		}
+
+		e.streamMtx.Lock()
+		if e.base.listID == c.listID && !e.stopped && !reflect.DeepEqual(members, e.listMembers) {
+			logInfo("Members of list have changed", "list", c.listID, "members", len(members))
+			e.listMembers = members
+			if err := e.restart(); err != nil {
+				logError("Error restarting stream with new list members", "err", err)
+			}
+		}
+		e.streamMtx.Unlock()
+	}
+}
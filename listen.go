@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// unixPrefix marks a -web.listen-address which is a Unix domain socket.
+const unixPrefix = "unix://"
+
+// listen opens the listener for addr, which is either a TCP address or
+// unix:// followed by the path of a socket to create with permissions mode.
+func listen(addr string, mode os.FileMode) (net.Listener, error) {
+	if !strings.HasPrefix(addr, unixPrefix) {
+		return net.Listen("tcp", addr)
+	}
+	path := strings.TrimPrefix(addr, unixPrefix)
+	// A socket left behind by an exporter which didn't shut down cleanly
+	// would stop the listener being created.
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// parseSocketMode parses octal file permissions such as 0660.
+func parseSocketMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("invalid socket mode %q, must be octal permissions such as 0660", s)
+	}
+	return os.FileMode(m), nil
+}
+
+// limitRequests wraps h to serve at most max requests at once, responding
+// to further requests with 503 Service Unavailable, so that a pile-up of
+// slow scrapes can't exhaust memory. A max of zero is unlimited.
+func limitRequests(h http.Handler, max int) http.Handler {
+	if max <= 0 {
+		return h
+	}
+	inFlight := make(chan struct{}, max)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case inFlight <- struct{}{}:
+			defer func() { <-inFlight }()
+			h.ServeHTTP(w, r)
+		default:
+			http.Error(w, fmt.Sprintf("Limit of %d concurrent requests reached", max), http.StatusServiceUnavailable)
+		}
+	})
+}
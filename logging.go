@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logSeverity is the severity of a log message.
+type logSeverity int
+
+const (
+	levelDebug logSeverity = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l logSeverity) String() string {
+	return levelNames[l]
+}
+
+// parseLogLevel returns the level named s.
+func parseLogLevel(s string) (logSeverity, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return logSeverity(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, must be one of %s", s, strings.Join(levelNames, ", "))
+}
+
+// leveledLogger writes messages at or above a minimum level as logfmt or
+// JSON lines, with context given as alternating keys and values.
+type leveledLogger struct {
+	mtx    sync.Mutex
+	out    io.Writer
+	level  logSeverity
+	format string
+}
+
+var logger = &leveledLogger{out: os.Stderr, level: levelInfo, format: "logfmt"}
+
+// setupLogging configures the logger from the name of the minimum level and
+// the format, and sends anything written with the standard log package,
+// such as net/http's errors, through it.
+func setupLogging(level, format string) error {
+	l, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	if format != "logfmt" && format != "json" {
+		return fmt.Errorf("unknown log format %q, must be logfmt or json", format)
+	}
+	logger.mtx.Lock()
+	logger.level, logger.format = l, format
+	logger.mtx.Unlock()
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(stdLogWriter{})
+	return nil
+}
+
+// logEnabled reports whether messages at level l are written, so that
+// callers can skip building expensive context.
+func logEnabled(l logSeverity) bool {
+	logger.mtx.Lock()
+	defer logger.mtx.Unlock()
+	return l >= logger.level
+}
+
+func logDebug(msg string, kv ...interface{}) { logger.log(levelDebug, msg, kv) }
+func logInfo(msg string, kv ...interface{})  { logger.log(levelInfo, msg, kv) }
+func logWarn(msg string, kv ...interface{})  { logger.log(levelWarn, msg, kv) }
+func logError(msg string, kv ...interface{}) { logger.log(levelError, msg, kv) }
+
+// logFatal logs msg as an error and exits.
+func logFatal(msg string, kv ...interface{}) {
+	logger.log(levelError, msg, kv)
+	os.Exit(1)
+}
+
+func (l *leveledLogger) log(level logSeverity, msg string, kv []interface{}) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if level < l.level {
+		return
+	}
+	fields := append([]interface{}{"ts", time.Now().UTC().Format(time.RFC3339Nano), "level", level.String(), "msg", msg}, kv...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "")
+	}
+	var b bytes.Buffer
+	if l.format == "json" {
+		b.WriteByte('{')
+		for i := 0; i < len(fields); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			k, _ := json.Marshal(fmt.Sprint(fields[i]))
+			b.Write(k)
+			b.WriteByte(':')
+			b.Write(jsonLogValue(fields[i+1]))
+		}
+		b.WriteString("}\n")
+	} else {
+		for i := 0; i < len(fields); i += 2 {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			v := logValue(fields[i+1])
+			if v == "" || strings.ContainsAny(v, " =\"\\\n\t") {
+				v = strconv.Quote(v)
+			}
+			fmt.Fprintf(&b, "%v=%s", fields[i], v)
+		}
+		b.WriteByte('\n')
+	}
+	l.out.Write(b.Bytes())
+}
+
+// logValue formats a value for a log line.
+func logValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case error:
+		if v == nil {
+			return ""
+		}
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// jsonLogValue formats a value for a JSON log line, keeping numbers and
+// booleans as they are.
+func jsonLogValue(v interface{}) []byte {
+	switch v.(type) {
+	case int, int32, int64, uint, uint32, uint64, float32, float64, bool:
+		if b, err := json.Marshal(v); err == nil {
+			return b
+		}
+	}
+	b, _ := json.Marshal(logValue(v))
+	return b
+}
+
+// stdLogWriter logs lines written by the standard log package as errors.
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	logError(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// hashtagPattern matches the keywords which can be streamed from Mastodon,
+// which only filters statuses by hashtag.
+var hashtagPattern = regexp.MustCompile(`^#?[\pL\pN_]+$`)
+
+// mastodonTags returns the hashtags to stream for the keywords in track.
+func mastodonTags(track []string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, t := range filterTerms(track) {
+		tag := strings.ToLower(strings.TrimPrefix(t, "#"))
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// getMastodonClient returns an HTTP client which authenticates requests to
+// the Mastodon instance in c.
+func getMastodonClient(c twitterConfig) *http.Client {
+	return &http.Client{Transport: &bearerTransport{token: c.mastodonToken, next: proxyTransport(c.proxyURL)}}
+}
+
+// testMastodonAuth checks the configured access token against the Mastodon
+// instance and prints the account it belongs to, returning the process's exit
+// code.
+func testMastodonAuth(c twitterConfig) int {
+	resp, err := getMastodonClient(c).Get(strings.TrimSuffix(c.mastodonURL, "/") + "/api/v1/accounts/verify_credentials")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error verifying credentials: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "Mastodon rejected the access token with %s\n", resp.Status)
+		return 1
+	}
+	a := mastodonAccount{}
+	if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing account: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Authenticated as @%s on %s\n", a.Acct, resp.Request.URL.Host)
+	if remaining := resp.Header.Get("X-RateLimit-Remaining"); remaining != "" {
+		fmt.Printf("%s of %s requests remaining, resetting at %s\n",
+			remaining, resp.Header.Get("X-RateLimit-Limit"), resp.Header.Get("X-RateLimit-Reset"))
+	}
+	return 0
+}
+
+// mastodonStatus is the subset of a Mastodon status which is exported.
+// https://docs.joinmastodon.org/entities/Status/
+type mastodonStatus struct {
+	ID                 string          `json:"id"`
+	CreatedAt          string          `json:"created_at"`
+	InReplyToAccountID string          `json:"in_reply_to_account_id"`
+	Language           string          `json:"language"`
+	Content            string          `json:"content"`
+	Account            mastodonAccount `json:"account"`
+	Reblog             *mastodonStatus `json:"reblog"`
+	Tags               []struct {
+		Name string `json:"name"`
+	} `json:"tags"`
+	Mentions        []mastodonAccount `json:"mentions"`
+	ReblogsCount    int               `json:"reblogs_count"`
+	FavouritesCount int               `json:"favourites_count"`
+}
+
+// mastodonAccount is an account which posted or was mentioned in a status.
+type mastodonAccount struct {
+	ID   string `json:"id"`
+	Acct string `json:"acct"`
+}
+
+// tweet converts s to a tweet, so that it can be counted in the same way.
+// Accounts are named by their acct, which includes the instance for remote
+// accounts.
+func (s *mastodonStatus) tweet() *twitter.Tweet {
+	t := &twitter.Tweet{
+		IDStr:    s.ID,
+		Text:     htmlText(s.Content),
+		Lang:     s.Language,
+		User:     &twitter.User{IDStr: s.Account.ID, ScreenName: s.Account.Acct},
+		Entities: &twitter.Entities{},
+
+		InReplyToUserIDStr: s.InReplyToAccountID,
+
+		RetweetCount:  s.ReblogsCount,
+		FavoriteCount: s.FavouritesCount,
+	}
+	if ts, err := time.Parse(time.RFC3339, s.CreatedAt); err == nil {
+		t.CreatedAt = ts.Format(time.RubyDate)
+	}
+	for _, tag := range s.Tags {
+		t.Entities.Hashtags = append(t.Entities.Hashtags, twitter.HashtagEntity{Text: tag.Name})
+	}
+	for _, m := range s.Mentions {
+		t.Entities.UserMentions = append(t.Entities.UserMentions, twitter.MentionEntity{IDStr: m.ID, ScreenName: m.Acct})
+		if m.ID == s.InReplyToAccountID {
+			t.InReplyToScreenName = m.Acct
+		}
+	}
+	if s.InReplyToAccountID == s.Account.ID {
+		t.InReplyToScreenName = s.Account.Acct
+	}
+	if s.Reblog != nil {
+		t.RetweetedStatus = s.Reblog.tweet()
+	}
+	return t
+}
+
+var (
+	htmlBreakPattern = regexp.MustCompile(`(?i)<br\s*/?>|</p>`)
+	htmlTagPattern   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// htmlText returns the text of a status's HTML content.
+func htmlText(s string) string {
+	s = htmlBreakPattern.ReplaceAllString(s, "\n")
+	return html.UnescapeString(htmlTagPattern.ReplaceAllString(s, ""))
+}
+
+// mastodonSource merges the streaming timelines of a Mastodon instance into a
+// single channel of tweets. When any of the timelines ends the others are
+// closed too, so that the stream can be reopened as a whole.
+type mastodonSource struct {
+	msgs chan interface{}
+	st   *statusTransport
+	o    streamObserver
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	once   sync.Once
+	group  sync.WaitGroup
+
+	// seen avoids counting statuses with several tracked hashtags more
+	// than once.
+	seen *recentIDs
+}
+
+// Connect implements TweetSource, streaming the public timeline of the
+// instance in c in sample mode, or the timeline of each tracked hashtag in
+// filter mode.
+func (s *mastodonSource) Connect(c twitterConfig, o streamObserver) error {
+	base, err := url.Parse(strings.TrimSuffix(c.mastodonURL, "/"))
+	if err != nil {
+		return err
+	}
+	s.o = o
+	hc := getMastodonClient(c)
+	s.st = &statusTransport{next: hc.Transport, observer: o, host: base.Host}
+	hc.Transport = s.st
+
+	var urls []string
+	if c.mode == "sample" {
+		urls = append(urls, base.String()+"/api/v1/streaming/public")
+	} else {
+		for _, tag := range mastodonTags(c.track) {
+			urls = append(urls, base.String()+"/api/v1/streaming/hashtag?tag="+url.QueryEscape(tag))
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.msgs = make(chan interface{})
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	s.seen = newRecentIDs()
+	for _, u := range urls {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			cancel()
+			return err
+		}
+		s.group.Add(1)
+		go s.receive(hc, req.WithContext(ctx))
+	}
+	go func() {
+		s.group.Wait()
+		close(s.msgs)
+	}()
+	return nil
+}
+
+// receive delivers the statuses in the response to req until it ends or the
+// stream is stopped.
+func (s *mastodonSource) receive(hc *http.Client, req *http.Request) {
+	defer s.group.Done()
+	defer s.stop()
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		s.send(err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	if err := s.readEvents(resp.Body); err != nil {
+		s.send(err)
+	}
+}
+
+// readEvents parses the server-sent events in r, sending the statuses from
+// update events as tweets.
+func (s *mastodonSource) readEvents(r io.Reader) error {
+	var event string
+	var data []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event == "update" {
+				st := &mastodonStatus{}
+				if err := json.Unmarshal([]byte(strings.Join(data, "\n")), st); err != nil {
+					logWarn("Error parsing Mastodon status", "err", err)
+					s.o.parseError()
+				} else if s.seen.add(st.ID) {
+					t := st.tweet()
+					recordTweet(s.o, t)
+					s.send(t)
+				}
+			}
+			event, data = "", nil
+		case strings.HasPrefix(line, ":"):
+			// Comments are sent as keep-alives.
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(line[len("event:"):])
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(line[len("data:"):], " "))
+		}
+	}
+	select {
+	case <-s.done:
+		return nil
+	default:
+		return scanner.Err()
+	}
+}
+
+// send delivers msg unless the stream has been stopped.
+func (s *mastodonSource) send(msg interface{}) {
+	select {
+	case s.msgs <- msg:
+	case <-s.done:
+	}
+}
+
+// stop closes all of the stream's connections without waiting for them.
+func (s *mastodonSource) stop() {
+	s.once.Do(func() {
+		close(s.done)
+		s.cancel()
+	})
+}
+
+// Stop implements TweetSource, closing the stream and waiting for its
+// connections to finish.
+func (s *mastodonSource) Stop() {
+	s.stop()
+	s.group.Wait()
+}
+
+// Messages implements TweetSource.
+func (s *mastodonSource) Messages() <-chan interface{} {
+	return s.msgs
+}
+
+// lastStatus implements statusReporter.
+func (s *mastodonSource) lastStatus() int {
+	return s.st.lastStatus()
+}
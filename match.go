@@ -0,0 +1,359 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// regexpPrefix marks a track entry as a regular expression which is matched
+// locally rather than being sent to Twitter.
+const regexpPrefix = "re:"
+
+// keyword describes a tracked keyword and how mentions of it are matched and
+// labelled.
+type keyword struct {
+	// term is the text which is matched. It's lowercase unless the keyword
+	// is case-sensitive.
+	term          string
+	label         string
+	group         string
+	caseSensitive bool
+	substring     bool
+	pattern       *regexp.Regexp
+}
+
+// keywordOptions are the per-keyword matching options from the config file.
+type keywordOptions struct {
+	Keyword       string `yaml:"keyword"`
+	CaseSensitive bool   `yaml:"case_sensitive"`
+	// Match is either "word", the default, or "substring".
+	Match string `yaml:"match"`
+	// Label replaces the keyword in the keyword label of mention metrics.
+	Label string `yaml:"label"`
+}
+
+// isPattern reports whether a track entry is a regular expression.
+func isPattern(s string) bool {
+	return strings.HasPrefix(s, regexpPrefix)
+}
+
+// compilePattern compiles a regular expression track entry, which is
+// case-insensitive unless caseSensitive is set.
+func compilePattern(s string, caseSensitive bool) (*regexp.Regexp, error) {
+	expr := strings.TrimPrefix(s, regexpPrefix)
+	if !caseSensitive {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// filterTerms returns the track entries which should be sent to Twitter.
+func filterTerms(track []string) []string {
+	var terms []string
+	for _, t := range track {
+		if !isPattern(t) {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// buildKeywords returns the keywords tracked by c, indexed by the term which
+// is matched.
+func buildKeywords(c twitterConfig) map[string]keyword {
+	opts := map[string]keywordOptions{}
+	for _, o := range c.keywords {
+		opts[strings.ToLower(o.Keyword)] = o
+	}
+	groupOf := map[string]string{}
+	for g, l := range c.groups {
+		for _, k := range l {
+			groupOf[strings.ToLower(k)] = g
+		}
+	}
+	canonicalOf := map[string]string{}
+	for canonical, l := range c.aliases {
+		for _, k := range l {
+			canonicalOf[strings.ToLower(k)] = canonical
+		}
+	}
+	// Case-sensitive keywords keep their original case in labels.
+	normalise := func(s string) string {
+		if isPattern(s) || opts[strings.ToLower(s)].CaseSensitive {
+			return s
+		}
+		return strings.ToLower(s)
+	}
+
+	kw := map[string]keyword{}
+	for _, t := range c.track {
+		lt := strings.ToLower(t)
+		k := keyword{
+			term:          normalise(t),
+			label:         normalise(t),
+			group:         groupOf[lt],
+			caseSensitive: opts[lt].CaseSensitive,
+			substring:     opts[lt].Match == "substring",
+		}
+		// Words in CJK scripts aren't separated by spaces, so they can only
+		// be found as substrings.
+		if containsCJK(t) {
+			k.substring = true
+		}
+		if isPattern(t) {
+			p, err := compilePattern(t, k.caseSensitive)
+			if err != nil {
+				// Rejected by config validation.
+				continue
+			}
+			k.pattern = p
+			k.label = strings.TrimPrefix(t, regexpPrefix)
+		}
+		if canonical, ok := canonicalOf[lt]; ok {
+			k.label = normalise(canonical)
+			k.group = groupOf[strings.ToLower(canonical)]
+		}
+		if opts[lt].Label != "" {
+			k.label = opts[lt].Label
+		}
+		kw[k.term] = k
+	}
+	return kw
+}
+
+// matcher finds tracked keywords in tweet text and entities.
+type matcher struct {
+	// folded holds case-insensitive whole-word keywords by lowercase term.
+	folded map[string]keyword
+	// exact holds case-sensitive whole-word keywords.
+	exact map[string]keyword
+	// cashtags holds keywords written as cashtags, such as "$AAPL", by
+	// their lowercase symbol.
+	cashtags map[string]keyword
+	// substrings holds keywords which may appear anywhere within a word.
+	substrings []keyword
+	// patterns holds regular expression keywords.
+	patterns []keyword
+	// phrases holds keywords made up of several words.
+	phrases []phrase
+	// foldDiacritics strips accents from keywords and tweet text before
+	// they're compared.
+	foldDiacritics bool
+}
+
+// phrase is a multi-word keyword split into its words.
+type phrase struct {
+	keyword
+	words []string
+}
+
+// newMatcher returns a matcher for the given keywords, optionally ignoring
+// diacritics.
+func newMatcher(keywords map[string]keyword, foldDiacritics bool) *matcher {
+	m := &matcher{
+		folded:         map[string]keyword{},
+		exact:          map[string]keyword{},
+		cashtags:       map[string]keyword{},
+		foldDiacritics: foldDiacritics,
+	}
+	for t, k := range keywords {
+		if k.pattern == nil {
+			t = m.fold(t)
+			k.term = m.fold(k.term)
+		}
+		switch {
+		case k.pattern != nil:
+			m.patterns = append(m.patterns, k)
+		case len(strings.Fields(t)) > 1:
+			m.phrases = append(m.phrases, phrase{keyword: k, words: strings.Fields(t)})
+		case k.substring:
+			m.substrings = append(m.substrings, k)
+		case cashtagPattern.MatchString(t):
+			m.cashtags[strings.ToLower(t[1:])] = k
+		case k.caseSensitive:
+			m.exact[t] = k
+		default:
+			m.folded[t] = k
+		}
+	}
+	return m
+}
+
+// fold removes diacritics from s if the matcher ignores them.
+func (m *matcher) fold(s string) string {
+	if !m.foldDiacritics {
+		return s
+	}
+	return removeDiacritics(s)
+}
+
+// token calls fn for each occurrence of a keyword in a single hashtag,
+// username or word.
+func (m *matcher) token(tok string, fn func(keyword)) {
+	m.match(m.fold(tok), fn)
+}
+
+// cashtag calls fn for each keyword matching the stock symbol sym, which is
+// given without its '$'. Keywords match whether or not they were written
+// with the '$'.
+func (m *matcher) cashtag(sym string, fn func(keyword)) {
+	if k, ok := m.cashtags[strings.ToLower(m.fold(sym))]; ok {
+		fn(k)
+	}
+	m.token(sym, fn)
+}
+
+// match is token for text which has already been folded.
+func (m *matcher) match(tok string, fn func(keyword)) {
+	lt := strings.ToLower(tok)
+	if k, ok := m.exact[tok]; ok {
+		fn(k)
+	}
+	if k, ok := m.folded[lt]; ok {
+		fn(k)
+	}
+	for _, k := range m.substrings {
+		hay := lt
+		if k.caseSensitive {
+			hay = tok
+		}
+		for n := strings.Count(hay, k.term); n > 0; n-- {
+			fn(k)
+		}
+	}
+	for _, k := range m.patterns {
+		for n := len(k.pattern.FindAllStringIndex(tok, -1)); n > 0; n-- {
+			fn(k)
+		}
+	}
+}
+
+// text calls fn for each occurrence of a keyword in the words of text,
+// including phrases which appear contiguously.
+func (m *matcher) text(text string, fn func(keyword)) {
+	words := tokenize(m.fold(text))
+	for i, w := range words {
+		m.match(w, fn)
+		for _, p := range m.phrases {
+			if p.matchAt(words, i) {
+				fn(p.keyword)
+			}
+		}
+	}
+}
+
+// matchAt reports whether the phrase appears in words starting at index i.
+func (p phrase) matchAt(words []string, i int) bool {
+	if i+len(p.words) > len(words) {
+		return false
+	}
+	for j, pw := range p.words {
+		w := words[i+j]
+		if p.caseSensitive && w != pw || !p.caseSensitive && !strings.EqualFold(w, pw) {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteReplacer straightens curly quotes and apostrophes.
+var quoteReplacer = strings.NewReplacer(
+	"\u2018", "'", "\u2019", "'", "\u201a", "'", "\u201b", "'",
+	"\u201c", `"`, "\u201d", `"`, "\u201e", `"`, "\u201f", `"`,
+)
+
+// tokenize splits text into the words which keywords are matched against.
+// Curly quotes are straightened and punctuation surrounding each word is
+// dropped, apart from a leading '#', '@' or '$'. Runs of CJK characters are
+// split from adjacent text in other scripts, since they aren't separated by
+// spaces.
+func tokenize(text string) []string {
+	var words []string
+	for _, f := range strings.Fields(quoteReplacer.Replace(text)) {
+		for _, p := range splitScripts(f) {
+			if w := trimWord(p); w != "" {
+				words = append(words, w)
+			}
+		}
+	}
+	return words
+}
+
+// splitScripts splits s wherever its letters change between CJK and other
+// scripts.
+func splitScripts(s string) []string {
+	var parts []string
+	start, prev := 0, -1
+	for i, r := range s {
+		// Marks such as the katakana 'ー' belong to no particular script.
+		if !unicode.IsLetter(r) || unicode.Is(unicode.Common, r) {
+			continue
+		}
+		cur := 0
+		if isCJK(r) {
+			cur = 1
+		}
+		if prev >= 0 && cur != prev {
+			parts = append(parts, s[start:i])
+			start = i
+		}
+		prev = cur
+	}
+	return append(parts, s[start:])
+}
+
+// trimWord removes leading and trailing punctuation and symbols from w,
+// keeping a '#', '@' or '$' immediately before the first letter or digit.
+func trimWord(w string) string {
+	isTrimmed := func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) }
+	w = strings.TrimRightFunc(w, isTrimmed)
+	start := strings.IndexFunc(w, func(r rune) bool { return !isTrimmed(r) })
+	if start < 0 {
+		return ""
+	}
+	if start > 0 && strings.IndexByte("#@$", w[start-1]) >= 0 {
+		start--
+	}
+	return w[start:]
+}
+
+// removeDiacritics decomposes s and drops combining marks, so that "café"
+// becomes "cafe". Compatibility characters such as full-width letters are
+// replaced with their plain equivalents.
+func removeDiacritics(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return r
+	}, norm.NFKD.String(s))
+	return norm.NFC.String(s)
+}
+
+// cashtagPattern matches a stock symbol such as "$AAPL" or "$BRK.A".
+var cashtagPattern = regexp.MustCompile(`^\$[A-Za-z]{1,6}(?:[._][A-Za-z]{1,2})?$`)
+
+// cashtags returns the symbols of the cashtags in text, without the '$'. The
+// vendored client doesn't decode the symbols entity, so they're found in the
+// text instead.
+func cashtags(text string) []string {
+	var symbols []string
+	for _, w := range tokenize(text) {
+		if cashtagPattern.MatchString(w) {
+			symbols = append(symbols, w[1:])
+		}
+	}
+	return symbols
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// containsCJK reports whether s contains any CJK characters.
+func containsCJK(s string) bool {
+	return strings.IndexFunc(s, isCJK) >= 0
+}
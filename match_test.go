@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+func TestCashtagKeywords(t *testing.T) {
+	for _, tt := range []struct {
+		track []string
+		text  string
+		want  []string
+	}{
+		{[]string{"$AAPL"}, "buy $AAPL now", []string{"$aapl:cashtag"}},
+		{[]string{"$aapl"}, "buy $AaPl now", []string{"$aapl:cashtag"}},
+		{[]string{"aapl"}, "buy $AAPL now", []string{"aapl:cashtag"}},
+		{[]string{"aapl"}, "buy AAPL now", []string{"aapl:word"}},
+		{[]string{"$AAPL"}, "buy AAPL now", nil},
+		{[]string{"$BRK.A"}, "buy $BRK.A now", []string{"$brk.a:cashtag"}},
+		// Not a stock symbol, so matched as a word.
+		{[]string{"$100"}, "only $100", []string{"$100:word"}},
+	} {
+		m := newMatcher(buildKeywords(twitterConfig{track: tt.track}), false)
+		var got []string
+		findMentions(m, &twitter.Tweet{Text: tt.text, Entities: &twitter.Entities{}}, func(k keyword, matchType string) {
+			got = append(got, k.label+":"+matchType)
+		})
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("track %q, text %q: got matches %q, want %q", tt.track, tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// hashtagPairLimit returns the limit on the other_hashtag label of
+// co-mentions in c. Allowed hashtags may be given with or without a leading
+// '#'.
+func hashtagPairLimit(c twitterConfig) (int, []string) {
+	var allow []string
+	for _, h := range c.hashtagPairsAllow {
+		allow = append(allow, strings.TrimPrefix(h, "#"))
+	}
+	return c.hashtagPairsMax, allow
+}
+
+// countHashtagPairs counts each hashtag in s which isn't itself tracked as a
+// co-mention of every keyword in matched.
+func (e *Exporter) countHashtagPairs(p *labelLimit, m *matcher, s *twitter.Tweet, matched map[string]bool) {
+	if s.Entities == nil || len(matched) == 0 {
+		return
+	}
+	counted := map[string]bool{}
+	for _, h := range s.Entities.Hashtags {
+		if isTrackedHashtag(m, h.Text) {
+			continue
+		}
+		other, ok := p.label(h.Text)
+		if !ok || counted[other] {
+			continue
+		}
+		counted[other] = true
+		for kw := range matched {
+			e.hashtagPairs.WithLabelValues(e.series.values("twitter_stream_hashtag_pairs_total", kw, other)...).Inc()
+		}
+	}
+}
+
+// isTrackedHashtag reports whether the hashtag tag matches a keyword, which
+// may be given with or without the '#'.
+func isTrackedHashtag(m *matcher, tag string) bool {
+	tracked := false
+	m.token(tag, func(keyword) { tracked = true })
+	m.token("#"+tag, func(keyword) { tracked = true })
+	return tracked
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// errStreamPaused is returned by ready while the stream is paused.
+var errStreamPaused = errors.New("stream is paused")
+
+// Pause closes the stream and keeps it closed, including across reloads and
+// keyword changes, until Resume is called. Metrics are still served, and
+// keyword changes are reflected by Keywords straight away.
+func (e *Exporter) Pause() {
+	e.streamMtx.Lock()
+	defer e.streamMtx.Unlock()
+	if !atomic.CompareAndSwapInt32(&e.isPaused, 0, 1) {
+		return
+	}
+	if e.retryTimer != nil {
+		e.retryTimer.Stop()
+	}
+	if e.stream != nil {
+		e.stream.Stop()
+		e.stream = nil
+	}
+}
+
+// Resume reopens a paused stream. If that fails it's retried after a
+// backoff, as when reconnecting.
+func (e *Exporter) Resume() error {
+	e.streamMtx.Lock()
+	defer e.streamMtx.Unlock()
+	if !atomic.CompareAndSwapInt32(&e.isPaused, 1, 0) || e.stopped {
+		return nil
+	}
+	e.backoffs.reset()
+	return e.restart()
+}
+
+// pauseHandler pauses the stream, or resumes it if pause is false. Like the
+// keyword API, it requires the reload token.
+func pauseHandler(e *Exporter, token string, pause bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if token == "" {
+			http.Error(w, fmt.Sprintf("The stream can't be paused or resumed unless %s is set", envReloadToken), http.StatusForbidden)
+			return
+		}
+		if !authorized(w, r, token) {
+			return
+		}
+
+		res := struct {
+			Status string `json:"status"`
+			Error  string `json:"error,omitempty"`
+		}{}
+		w.Header().Set("Content-Type", "application/json")
+		if pause {
+			logInfo("Pausing stream", "remote_addr", r.RemoteAddr)
+			e.Pause()
+			res.Status = "paused"
+		} else {
+			logInfo("Resuming stream", "remote_addr", r.RemoteAddr)
+			res.Status = "resumed"
+			if err := e.Resume(); err != nil {
+				// The stream is no longer paused, and will be retried
+				// after a backoff.
+				logError("Error resuming stream", "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				res.Status = "reconnecting"
+				res.Error = err.Error()
+			}
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// Limits on search queries imposed by Twitter.
+// https://developer.twitter.com/en/docs/twitter-api/v1/tweets/search/guides/standard-operators
+const (
+	maxSearchQueryBytes = 500
+	maxSearchCount      = 100
+	// maxSearchPages limits how far back each poll pages through results
+	// when more tweets than fit in one page have been posted since the
+	// last poll.
+	maxSearchPages = 10
+)
+
+// searchQueries combines the keywords in track into as few search queries as
+// possible, each within Twitter's length limit.
+func searchQueries(track []string) []string {
+	var queries []string
+	var q string
+	for _, t := range filterTerms(track) {
+		term := t
+		if strings.Contains(t, " ") {
+			term = strconv.Quote(t)
+		}
+		if q != "" && len(q)+len(" OR ")+len(term) > maxSearchQueryBytes {
+			queries = append(queries, q)
+			q = ""
+		}
+		if q != "" {
+			q += " OR "
+		}
+		q += term
+	}
+	if q != "" {
+		queries = append(queries, q)
+	}
+	return queries
+}
+
+// searchCursors holds the ID of the newest tweet found by each search query,
+// so that tweets posted while the stream was reconnecting or being restarted
+// are still delivered, as long as the query hasn't changed.
+type searchCursors struct {
+	mtx sync.Mutex
+	ids map[string]int64
+}
+
+func newSearchCursors() *searchCursors {
+	return &searchCursors{ids: map[string]int64{}}
+}
+
+// start returns the ID to search from for each of queries, or zero for
+// those which haven't been run before, and forgets queries which are no
+// longer used.
+func (c *searchCursors) start(queries []string) []int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	ids := make([]int64, len(queries))
+	used := map[string]bool{}
+	for i, q := range queries {
+		ids[i] = c.ids[q]
+		used[q] = true
+	}
+	for q := range c.ids {
+		if !used[q] {
+			delete(c.ids, q)
+		}
+	}
+	return ids
+}
+
+// set records that query has found tweets up to id.
+func (c *searchCursors) set(query string, id int64) {
+	c.mtx.Lock()
+	c.ids[query] = id
+	c.mtx.Unlock()
+}
+
+// searchPoller periodically searches for recent tweets matching the tracked
+// keywords, delivering each new tweet once. The first search of a new query
+// only finds where to start from, so tweets posted before it was first run
+// aren't counted, but queries which were run by an earlier poller carry on
+// from where it stopped.
+type searchPoller struct {
+	client   *twitter.Client
+	queries  []string
+	sinceIDs []int64
+	started  []bool
+	cursors  *searchCursors
+	seen     *recentIDs
+	o        streamObserver
+
+	msgs chan interface{}
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+
+	mtx    sync.Mutex
+	status int
+}
+
+// newSearchPoller starts polling the search API every interval for the
+// keywords in c.
+func newSearchPoller(hc *http.Client, c twitterConfig, interval time.Duration, o streamObserver) *searchPoller {
+	queries := searchQueries(c.track)
+	p := &searchPoller{
+		client:   twitter.NewClient(hc),
+		queries:  queries,
+		sinceIDs: o.searchCursors().start(queries),
+		started:  make([]bool, len(queries)),
+		cursors:  o.searchCursors(),
+		seen:     newRecentIDs(),
+		o:        o,
+		msgs:     make(chan interface{}),
+		done:     make(chan struct{}),
+	}
+	for i, id := range p.sinceIDs {
+		p.started[i] = id != 0
+	}
+	p.wg.Add(1)
+	go p.run(interval)
+	return p
+}
+
+// run polls until a search fails or the poller is stopped.
+func (p *searchPoller) run(interval time.Duration) {
+	defer p.wg.Done()
+	defer close(p.msgs)
+	defer p.o.setConnected(false)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		for i := range p.queries {
+			if !p.poll(i) {
+				return
+			}
+		}
+		select {
+		case <-t.C:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// poll runs the ith query, delivering the tweets posted since its last run
+// oldest first. It reports whether polling should continue.
+func (p *searchPoller) poll(i int) bool {
+	var tweets []twitter.Tweet
+	var maxID int64
+	for page := 0; page < maxSearchPages; page++ {
+		params := &twitter.SearchTweetParams{
+			Query:      p.queries[i],
+			ResultType: "recent",
+			Count:      maxSearchCount,
+			SinceID:    p.sinceIDs[i],
+			MaxID:      maxID,
+		}
+		search, resp, err := p.client.Search.Tweets(params)
+		if resp != nil {
+			p.setStatus(resp.StatusCode)
+		}
+		if err != nil {
+			if resp == nil {
+				p.send(err)
+			}
+			return false
+		}
+		p.o.setConnected(true)
+		tweets = append(tweets, search.Statuses...)
+		// Without a starting point, only the newest page is needed.
+		if !p.started[i] || len(search.Statuses) < maxSearchCount {
+			break
+		}
+		maxID = search.Statuses[len(search.Statuses)-1].ID - 1
+	}
+
+	first := !p.started[i]
+	p.started[i] = true
+	for _, t := range tweets {
+		if t.ID > p.sinceIDs[i] {
+			p.sinceIDs[i] = t.ID
+		}
+	}
+	if first {
+		p.cursors.set(p.queries[i], p.sinceIDs[i])
+		return true
+	}
+	for j := len(tweets) - 1; j >= 0; j-- {
+		if p.seen.add(tweets[j].IDStr) {
+			// Search results aren't one tweet per line like the stream,
+			// so the tweets are recorded rather than the responses.
+			recordTweet(p.o, &tweets[j])
+			if !p.send(&tweets[j]) {
+				return false
+			}
+		}
+		// The cursor only passes tweets which have been delivered, so that
+		// stopping part way through doesn't skip the rest.
+		p.cursors.set(p.queries[i], tweets[j].ID)
+	}
+	p.cursors.set(p.queries[i], p.sinceIDs[i])
+	return true
+}
+
+// setStatus records the status of a search response.
+func (p *searchPoller) setStatus(code int) {
+	p.o.streamStatus(code)
+	p.mtx.Lock()
+	p.status = code
+	p.mtx.Unlock()
+}
+
+// send delivers msg, reporting false if the poller has been stopped.
+func (p *searchPoller) send(msg interface{}) bool {
+	select {
+	case p.msgs <- msg:
+		return true
+	case <-p.done:
+		return false
+	}
+}
+
+// lastStatus returns the status of the most recent search response.
+func (p *searchPoller) lastStatus() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.status
+}
+
+// Stop stops polling and waits for the current poll to finish.
+func (p *searchPoller) Stop() {
+	p.once.Do(func() { close(p.done) })
+	p.wg.Wait()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// testObserver is a streamObserver which ignores everything but the search
+// cursors and recorder.
+type testObserver struct {
+	cursors *searchCursors
+	rec     *recorder
+}
+
+func (o *testObserver) setConnected(bool)             {}
+func (o *testObserver) streamActivity()               {}
+func (o *testObserver) streamStatus(int)              {}
+func (o *testObserver) messageRecorder() *recorder    { return o.rec }
+func (o *testObserver) searchCursors() *searchCursors { return o.cursors }
+func (o *testObserver) parseError()                   {}
+
+// redirectTransport sends every request to the server at target.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme, req.URL.Host = t.target.Scheme, t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestSearchPollerResumes(t *testing.T) {
+	var mtx sync.Mutex
+	var ids []int64
+	post := func(id int64) {
+		mtx.Lock()
+		ids = append(ids, id)
+		mtx.Unlock()
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		since, _ := strconv.ParseInt(r.FormValue("since_id"), 10, 64)
+		res := twitter.Search{Statuses: []twitter.Tweet{}}
+		mtx.Lock()
+		for i := len(ids) - 1; i >= 0; i-- {
+			if ids[i] > since {
+				res.Statuses = append(res.Statuses, twitter.Tweet{ID: ids[i], IDStr: strconv.FormatInt(ids[i], 10), User: &twitter.User{IDStr: "1"}})
+			}
+		}
+		mtx.Unlock()
+		json.NewEncoder(w).Encode(res)
+	}))
+	defer s.Close()
+	target, _ := url.Parse(s.URL)
+	hc := &http.Client{Transport: &redirectTransport{target: target}}
+	dir, err := ioutil.TempDir("", "poll_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rec, err := newRecorder(dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &testObserver{cursors: newSearchCursors(), rec: rec}
+	c := twitterConfig{track: []string{"golang"}}
+
+	// The first poller only finds where to start from.
+	post(1)
+	p := newSearchPoller(hc, c, time.Hour, o)
+	select {
+	case msg := <-p.msgs:
+		t.Errorf("got %v from the first search, want nothing", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	p.Stop()
+
+	// Tweets posted while reconnecting are delivered by the next poller.
+	post(2)
+	post(3)
+	p = newSearchPoller(hc, c, time.Hour, o)
+	for _, want := range []int64{2, 3} {
+		select {
+		case msg := <-p.msgs:
+			if tw, ok := msg.(*twitter.Tweet); !ok || tw.ID != want {
+				t.Errorf("got %v, want tweet %d", msg, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("got no tweet, want tweet %d", want)
+		}
+	}
+	p.Stop()
+
+	// A changed query starts afresh.
+	post(4)
+	p = newSearchPoller(hc, twitterConfig{track: []string{"rustlang"}}, time.Hour, o)
+	select {
+	case msg := <-p.msgs:
+		t.Errorf("got %v from the first search of a new query, want nothing", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	p.Stop()
+	if len(o.cursors.ids) != 1 || o.cursors.ids["rustlang"] != 4 {
+		t.Errorf("got cursors %v, want only rustlang's", o.cursors.ids)
+	}
+
+	// Only the delivered tweets are recorded, one per line.
+	rec.Close()
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl.gz"))
+	if len(files) != 1 {
+		t.Fatalf("got recordings %q, want one", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded []int64
+	for sc := bufio.NewScanner(gz); sc.Scan(); {
+		if tw := parseRecordedTweet(sc.Bytes()); tw != nil {
+			recorded = append(recorded, tw.ID)
+		}
+	}
+	if want := []int64{2, 3}; !reflect.DeepEqual(recorded, want) {
+		t.Errorf("recorded tweets %v, want %v", recorded, want)
+	}
+}
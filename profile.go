@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// countProfileMentions counts the keywords in the screen name, display name
+// and bio of the author of t, once per field. Profiles have no entities, so
+// hashtags and mentions are matched from their text.
+func (e *Exporter) countProfileMentions(m *matcher, t *twitter.Tweet) {
+	if t.User == nil {
+		return
+	}
+	count := func(field string) func(keyword) {
+		counted := map[string]bool{}
+		return func(kw keyword) {
+			if !counted[kw.label] {
+				counted[kw.label] = true
+				e.profileMentions.WithLabelValues(e.series.values("twitter_stream_profile_mentions_total", kw.label, field)...).Inc()
+			}
+		}
+	}
+	m.token(t.User.ScreenName, count("screen_name"))
+	profileText(m, t.User.Name, count("name"))
+	profileText(m, t.User.Description, count("description"))
+}
+
+// profileText calls fn for each keyword in text, including hashtags and
+// mentions.
+func profileText(m *matcher, text string, fn func(keyword)) {
+	m.text(text, fn)
+	for _, w := range tokenize(text) {
+		if len(w) > 1 && strings.IndexByte("#@", w[0]) >= 0 {
+			m.token(w[1:], fn)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// parseProxyURL parses the address of an HTTP, HTTPS or SOCKS5 proxy.
+func parseProxyURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid proxy URL %q: %v", s, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("Unsupported proxy URL %q, the scheme must be http, https or socks5", s)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Proxy URL %q has no host", s)
+	}
+	return u, nil
+}
+
+// proxyTransport returns a transport which connects through the proxy at u,
+// or the default transport, which respects HTTPS_PROXY and HTTP_PROXY, if u
+// is nil.
+func proxyTransport(u *url.URL) http.RoundTripper {
+	if u == nil {
+		return http.DefaultTransport
+	}
+	t := &http.Transport{
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if u.Scheme == "socks5" {
+		dialer, err := proxy.FromURL(u, &net.Dialer{Timeout: 30 * time.Second})
+		if err != nil {
+			// parseProxyURL has already rejected unknown schemes.
+			panic(err)
+		}
+		t.Dial = dialer.Dial
+	} else {
+		t.Proxy = http.ProxyURL(u)
+	}
+	return t
+}
+
+// proxyDial opens a connection to addr through the proxy at u, tunnelling
+// with CONNECT through HTTP proxies. If u is nil the proxy is chosen from
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY as it would be for requests to target.
+func proxyDial(u, target *url.URL, addr string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: 30 * time.Second}
+	if u == nil {
+		var err error
+		if u, err = http.ProxyFromEnvironment(&http.Request{URL: target}); err != nil {
+			return nil, err
+		}
+		if u == nil {
+			return d.Dial("tcp", addr)
+		}
+	}
+	if u.Scheme == "socks5" {
+		dialer, err := proxy.FromURL(u, d)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.Dial("tcp", addr)
+	}
+
+	paddr := u.Host
+	if u.Port() == "" {
+		paddr = net.JoinHostPort(u.Hostname(), map[string]string{"http": "80", "https": "443"}[u.Scheme])
+	}
+	conn, err := d.Dial("tcp", paddr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+pass)))
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// The proxy doesn't send anything after its response until the
+	// connection is used, so nothing is lost with the reader.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", u.Host, addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
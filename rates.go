@@ -0,0 +1,192 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// rateWindow is the window over which twitter_stream_tweets_rate_5m is
+// calculated, in rateSlices slices.
+const (
+	rateWindow = 5 * time.Minute
+	rateSlices = 60
+)
+
+// Parameters of the baselines anomaly scores are calculated from. Each
+// keyword's matches are counted per baselineInterval, and the mean and
+// variance of the counts are averaged with a weight of baselineAlpha, which
+// gives a baseline of roughly the last hour. No score is given until
+// baselineWarmup intervals have been seen.
+const (
+	baselineInterval = time.Minute
+	baselineAlpha    = 2.0 / 61
+	baselineWarmup   = 10
+	// baselineMinStddev stops quiet keywords with little variation from
+	// scoring highly for a single tweet.
+	baselineMinStddev = 1
+	// baselineMaxGap is the longest gap between updates which is filled in
+	// with empty intervals. After a longer gap the baseline starts again.
+	baselineMaxGap = 24 * time.Hour
+)
+
+// slidingCounter counts events within a sliding window, by keeping a count
+// for each of several slices of the window.
+type slidingCounter struct {
+	width  time.Duration
+	counts []float64
+	epochs []int64
+}
+
+func newSlidingCounter(window time.Duration, slices int) *slidingCounter {
+	return &slidingCounter{width: window / time.Duration(slices), counts: make([]float64, slices), epochs: make([]int64, slices)}
+}
+
+// add counts an event at time now.
+func (s *slidingCounter) add(now time.Time) {
+	epoch := now.UnixNano() / int64(s.width)
+	i := int(epoch % int64(len(s.counts)))
+	if s.epochs[i] != epoch {
+		s.counts[i] = 0
+		s.epochs[i] = epoch
+	}
+	s.counts[i]++
+}
+
+// sum returns the number of events within the window ending at now.
+func (s *slidingCounter) sum(now time.Time) float64 {
+	epoch := now.UnixNano() / int64(s.width)
+	var sum float64
+	for i, c := range s.counts {
+		if epoch-s.epochs[i] < int64(len(s.counts)) {
+			sum += c
+		}
+	}
+	return sum
+}
+
+// baseline scores how unusual each interval's count of events is, compared
+// to an exponentially weighted moving mean and standard deviation of
+// previous intervals.
+type baseline struct {
+	start    time.Time
+	count    float64
+	mean     float64
+	variance float64
+	n        int
+	score    float64
+}
+
+func newBaseline(now time.Time) *baseline {
+	return &baseline{start: now}
+}
+
+// roll completes the intervals which have ended by now.
+func (b *baseline) roll(now time.Time) {
+	if now.Sub(b.start) > baselineMaxGap {
+		*b = baseline{start: now}
+		return
+	}
+	for !now.Before(b.start.Add(baselineInterval)) {
+		b.observe(b.count)
+		b.count = 0
+		b.start = b.start.Add(baselineInterval)
+	}
+}
+
+// observe scores the count x of a completed interval against the baseline,
+// then adds it to the baseline.
+func (b *baseline) observe(x float64) {
+	if b.n == 0 {
+		b.mean = x
+	}
+	if b.n >= baselineWarmup {
+		b.score = (x - b.mean) / math.Max(math.Sqrt(b.variance), baselineMinStddev)
+	}
+	diff := x - b.mean
+	incr := baselineAlpha * diff
+	b.mean += incr
+	b.variance = (1 - baselineAlpha) * (b.variance + diff*incr)
+	b.n++
+}
+
+// keywordRate is the recent activity of a single keyword.
+type keywordRate struct {
+	recent   *slidingCounter
+	baseline *baseline
+	// first and last are when the keyword was first and last matched, or
+	// zero if it hasn't been.
+	first, last time.Time
+}
+
+// keywordRates tracks the recent rate of tweets matching each keyword, for
+// consumers which can't calculate rates from counters themselves, and how
+// unusual it is.
+type keywordRates struct {
+	mtx      sync.Mutex
+	keywords map[string]*keywordRate
+}
+
+func newKeywordRates() *keywordRates {
+	return &keywordRates{keywords: map[string]*keywordRate{}}
+}
+
+// setKeywords starts tracking any new keywords in labels, so that their
+// rates are exported as zero until they're matched, and forgets any which
+// are no longer tracked.
+func (r *keywordRates) setKeywords(labels map[string]bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for kw := range r.keywords {
+		if !labels[kw] {
+			delete(r.keywords, kw)
+		}
+	}
+	now := time.Now()
+	for kw := range labels {
+		if _, ok := r.keywords[kw]; !ok {
+			r.keywords[kw] = &keywordRate{recent: newSlidingCounter(rateWindow, rateSlices), baseline: newBaseline(now)}
+		}
+	}
+}
+
+// add counts a tweet matching keyword.
+func (r *keywordRates) add(keyword string) {
+	now := time.Now()
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if k, ok := r.keywords[keyword]; ok {
+		k.recent.add(now)
+		k.baseline.roll(now)
+		k.baseline.count++
+		if k.first.IsZero() {
+			k.first = now
+		}
+		k.last = now
+	}
+}
+
+// each calls fn with the rate per second of tweets matching each keyword
+// over the window, and the anomaly score of the last complete interval: the
+// number of standard deviations its count was from the baseline.
+func (r *keywordRates) each(fn func(keyword string, rate, score float64)) {
+	now := time.Now()
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for kw, k := range r.keywords {
+		k.baseline.roll(now)
+		fn(kw, k.recent.sum(now)/rateWindow.Seconds(), k.baseline.score)
+	}
+}
+
+// seen calls fn with the times each keyword which has been matched was
+// first and last matched.
+func (r *keywordRates) seen(fn func(keyword string, first, last time.Time)) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for kw, k := range r.keywords {
+		if !k.first.IsZero() {
+			fn(kw, k.first, k.last)
+		}
+	}
+}
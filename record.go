@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// recorder archives raw stream messages to gzipped JSONL files in a
+// directory, starting a new file once the current one reaches maxBytes of
+// compressed data or has been open for maxAge.
+type recorder struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	mtx    sync.Mutex
+	f      *os.File
+	gz     *gzip.Writer
+	cw     *countingWriter
+	opened time.Time
+}
+
+// newRecorder returns a recorder writing to dir, creating it if necessary.
+func newRecorder(dir string, maxBytes int64, maxAge time.Duration) (*recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &recorder{dir: dir, maxBytes: maxBytes, maxAge: maxAge}, nil
+}
+
+// write appends msg to the current file as a single line. Errors are logged
+// rather than returned, so that recording problems don't interrupt the
+// stream.
+func (r *recorder) write(msg []byte) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.f != nil && (r.cw.n >= r.maxBytes || time.Since(r.opened) >= r.maxAge) {
+		if err := r.close(); err != nil {
+			logError("Error closing recording", "err", err)
+		}
+	}
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			logError("Error opening recording", "err", err)
+			return
+		}
+	}
+	if _, err := r.gz.Write(msg); err != nil {
+		logError("Error writing recording", "err", err)
+		return
+	}
+	r.gz.Write([]byte{'\n'})
+}
+
+// open starts a new file named after the current time. r.mtx must be held.
+func (r *recorder) open() error {
+	now := time.Now().UTC()
+	name := filepath.Join(r.dir, fmt.Sprintf("tweets-%s.jsonl.gz", now.Format("20060102T150405.000Z")))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.cw = &countingWriter{w: f}
+	r.gz = gzip.NewWriter(r.cw)
+	r.opened = now
+	return nil
+}
+
+// close finishes the current file. r.mtx must be held.
+func (r *recorder) close() error {
+	err := r.gz.Close()
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	r.f, r.gz, r.cw = nil, nil, nil
+	return err
+}
+
+// Close finishes the current file, if any, so that it can be read.
+func (r *recorder) Close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	return r.close()
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// recordTransport records each message in the bodies of stream responses.
+type recordTransport struct {
+	next http.RoundTripper
+	rec  *recorder
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK || req.URL.Host != streamHost {
+		return resp, err
+	}
+	resp.Body = &recordReader{ReadCloser: resp.Body, rec: t.rec}
+	return resp, nil
+}
+
+// recordReader passes a stream response body through unchanged, recording
+// each complete message in it. Blank keep-alive lines aren't recorded.
+type recordReader struct {
+	io.ReadCloser
+	rec *recorder
+	buf []byte
+}
+
+// Read implements io.Reader.
+func (r *recordReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	rest := r.buf
+	for {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			break
+		}
+		if line := bytes.TrimSpace(rest[:i]); len(line) > 0 {
+			r.rec.write(line)
+		}
+		rest = rest[i+1:]
+	}
+	r.buf = append(r.buf[:0], rest...)
+	return n, err
+}
+
+// recordTweet records t if o is recording. It's used by sources whose
+// messages are converted to tweets or, like search results, don't arrive one
+// per line, so that their recordings can be replayed with the file source.
+func recordTweet(o streamObserver, t *twitter.Tweet) {
+	rec := o.messageRecorder()
+	if rec == nil {
+		return
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		logError("Error recording tweet", "err", err)
+		return
+	}
+	rec.write(b)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// reloadMtx prevents SIGHUP and HTTP-triggered reloads from interleaving.
+var reloadMtx sync.Mutex
+
+// reloadResult describes the outcome of a configuration reload.
+type reloadResult struct {
+	Status   string   `json:"status"`
+	Error    string   `json:"error,omitempty"`
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Keywords int      `json:"keywords"`
+}
+
+// reloadConfig re-reads the configuration and restarts the exporter's stream
+// with it, reporting which keywords were added and removed.
+func reloadConfig(e *Exporter) (*reloadResult, error) {
+	reloadMtx.Lock()
+	defer reloadMtx.Unlock()
+
+	c, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	old := e.Keywords()
+	if err := e.Reload(c.twitter); err != nil {
+		return nil, err
+	}
+	cur := e.Keywords()
+
+	return diffKeywords(old, cur), nil
+}
+
+// diffKeywords returns a successful result listing the keywords in cur which
+// aren't in old and vice versa.
+func diffKeywords(old, cur map[string]keyword) *reloadResult {
+	res := &reloadResult{
+		Status:   "success",
+		Added:    []string{},
+		Removed:  []string{},
+		Keywords: len(cur),
+	}
+	for k := range cur {
+		if _, ok := old[k]; !ok {
+			res.Added = append(res.Added, k)
+		}
+	}
+	for k := range old {
+		if _, ok := cur[k]; !ok {
+			res.Removed = append(res.Removed, k)
+		}
+	}
+	sort.Strings(res.Added)
+	sort.Strings(res.Removed)
+	return res
+}
+
+// logReload reloads the configuration and logs the outcome.
+func logReload(e *Exporter) {
+	res, err := reloadConfig(e)
+	if err != nil {
+		logError("Error reloading configuration", "err", err)
+		return
+	}
+	logInfo("Reloaded configuration", "keywords", res.Keywords, "added", len(res.Added), "removed", len(res.Removed))
+}
+
+// reloadHandler triggers a configuration reload on POST requests carrying the
+// bearer token, and responds with a JSON description of the result.
+func reloadHandler(e *Exporter, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Only POST requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(w, r, token) {
+			return
+		}
+
+		logInfo("Reloading configuration", "remote_addr", r.RemoteAddr)
+		w.Header().Set("Content-Type", "application/json")
+		res, err := reloadConfig(e)
+		if err != nil {
+			logError("Error reloading configuration", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			res = &reloadResult{Status: "error", Error: err.Error(), Added: []string{}, Removed: []string{}, Keywords: len(e.Keywords())}
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+}
+
+// authorized checks that r carries token as a bearer token, responding with
+// 401 Unauthorized if it doesn't.
+func authorized(w http.ResponseWriter, r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// fileSource replays tweets recorded as newline-delimited JSON, such as the
+// raw messages from the streaming API. Messages other than tweets are
+// skipped. Once the file has been read the source stays open, so that it
+// isn't replayed again, until it's stopped.
+type fileSource struct {
+	msgs chan interface{}
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+// Connect implements TweetSource, replaying c.sourceFile at c.sourceRate
+// tweets per second, or as fast as possible if that's zero.
+func (s *fileSource) Connect(c twitterConfig, o streamObserver) error {
+	f, err := os.Open(c.sourceFile)
+	if err != nil {
+		return err
+	}
+	s.msgs = make(chan interface{})
+	s.done = make(chan struct{})
+	s.wg.Add(1)
+	go s.replay(f, c.sourceRate, o)
+	return nil
+}
+
+// replay delivers the tweets in f, pausing between them to keep to rate.
+func (s *fileSource) replay(f *os.File, rate float64, o streamObserver) {
+	defer s.wg.Done()
+	defer close(s.msgs)
+	defer f.Close()
+
+	var tick <-chan time.Time
+	if rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer t.Stop()
+		tick = t.C
+	}
+
+	o.setConnected(true)
+	defer o.setConnected(false)
+	var n, line int
+	var in io.Reader = f
+	if strings.HasSuffix(f.Name(), ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			logError("Error reading file", "path", f.Name(), "err", err)
+			o.setConnected(false)
+			<-s.done
+			return
+		}
+		in = gz
+	}
+	r := bufio.NewReader(in)
+	for {
+		b, err := r.ReadBytes('\n')
+		if len(b) > 0 {
+			line++
+			if t := parseRecordedTweet(b); t != nil {
+				if tick != nil {
+					select {
+					case <-tick:
+					case <-s.done:
+						return
+					}
+				}
+				select {
+				case s.msgs <- t:
+					n++
+				case <-s.done:
+					return
+				}
+			} else if len(bytes.TrimSpace(b)) > 0 {
+				logDebug("Skipping line which isn't a tweet", "path", f.Name(), "line", line)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	logInfo("Finished replaying tweets", "path", f.Name(), "tweets", n)
+	// Reporting the source as disconnected stops the idle watchdog from
+	// restarting it.
+	o.setConnected(false)
+	<-s.done
+}
+
+// parseRecordedTweet returns the tweet in a line of recorded JSON, or nil if
+// the line holds some other message.
+func parseRecordedTweet(b []byte) *twitter.Tweet {
+	b = expandTweet(bytes.TrimSpace(b))
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil
+	}
+	if _, ok := fields["id_str"]; !ok {
+		return nil
+	}
+	if _, ok := fields["user"]; !ok {
+		return nil
+	}
+	t := &twitter.Tweet{}
+	if err := json.Unmarshal(b, t); err != nil || t.User == nil {
+		return nil
+	}
+	if t.Entities == nil {
+		t.Entities = &twitter.Entities{}
+	}
+	return t
+}
+
+// Messages implements TweetSource.
+func (s *fileSource) Messages() <-chan interface{} {
+	return s.msgs
+}
+
+// Stop implements TweetSource.
+func (s *fileSource) Stop() {
+	s.once.Do(func() { close(s.done) })
+	s.wg.Wait()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// sentimentLexicon gives the valence of common English words, from -4 (most
+// negative) to 4 (most positive), in the style of VADER's lexicon.
+// https://github.com/cjhutto/vaderSentiment
+var sentimentLexicon = map[string]float64{
+	"abandon": -1.9, "abuse": -3.2, "accept": 1.4, "admire": 2.5, "adore": 2.6,
+	"afraid": -1.9, "agree": 1.5, "alarming": -2.0, "amazing": 2.8, "angry": -2.3,
+	"annoyed": -1.6, "annoying": -1.8, "anxious": -1.0, "appreciate": 2.1, "attack": -2.1,
+	"awesome": 3.1, "awful": -2.0, "bad": -2.5, "beautiful": 2.9, "best": 3.2,
+	"better": 1.9, "blame": -1.4, "bless": 1.8, "bored": -1.1, "boring": -1.3,
+	"brilliant": 2.8, "broken": -2.1, "bug": -1.1, "buggy": -1.8, "calm": 1.3,
+	"care": 2.2, "celebrate": 2.7, "cheap": -0.4, "cheer": 2.3, "clean": 1.7,
+	"clever": 2.0, "comfortable": 1.5, "complain": -1.5, "confused": -1.3, "congrats": 2.4,
+	"congratulations": 2.9, "cool": 1.3, "crap": -1.6, "crash": -1.7, "crazy": -1.4,
+	"crisis": -3.1, "cruel": -2.8, "cry": -2.1, "cute": 2.0, "damage": -2.2,
+	"danger": -2.4, "dead": -3.3, "death": -2.9, "delay": -1.3, "delight": 2.9,
+	"delighted": 3.1, "depressed": -2.3, "destroy": -2.5, "difficult": -1.5, "disappointed": -1.9,
+	"disappointing": -2.2, "disaster": -3.1, "disgusting": -2.4, "dislike": -1.6, "down": -0.8,
+	"dumb": -2.3, "easy": 1.9, "enjoy": 2.2, "error": -1.7, "evil": -3.4,
+	"excellent": 2.7, "excited": 1.4, "exciting": 2.2, "fail": -2.5, "failed": -2.3,
+	"failure": -2.3, "fair": 1.3, "fake": -2.1, "fantastic": 2.6, "fast": 1.1,
+	"fault": -1.7, "fear": -2.2, "fine": 0.8, "fix": 1.0, "fixed": 1.1,
+	"free": 2.3, "friendly": 2.2, "frustrated": -2.4, "frustrating": -1.9, "fun": 2.3,
+	"funny": 1.9, "glad": 2.0, "good": 1.9, "great": 3.1, "happy": 2.7,
+	"harm": -2.5, "hate": -2.7, "hated": -3.2, "hell": -3.6, "help": 1.7,
+	"helpful": 1.8, "hero": 2.6, "hope": 1.9, "horrible": -2.5, "hurt": -2.4,
+	"ill": -1.8, "impressive": 2.3, "improve": 1.9, "improved": 2.1, "interesting": 1.7,
+	"issue": -0.6, "joke": 1.2, "joy": 2.8, "kill": -3.7, "kind": 2.4,
+	"lame": -1.8, "laugh": 2.6, "lazy": -1.5, "liar": -3.1, "lie": -1.6,
+	"like": 1.5, "lost": -1.3, "love": 3.2, "loved": 2.9, "lovely": 2.8,
+	"luck": 2.0, "lucky": 1.8, "mad": -2.2, "mess": -1.5, "miss": -0.6,
+	"mistake": -1.4, "nice": 1.8, "outage": -1.8, "pain": -2.3, "panic": -2.3,
+	"perfect": 2.7, "pleased": 1.9, "poor": -2.1, "popular": 1.8, "praise": 2.6,
+	"pretty": 2.2, "problem": -1.7, "proud": 2.1, "rage": -2.6, "recommend": 1.5,
+	"relief": 2.1, "ridiculous": -1.5, "rip": -1.4, "sad": -2.1, "safe": 1.9,
+	"scam": -2.9, "scary": -2.2, "shame": -2.1, "shit": -2.6, "sick": -2.3,
+	"slow": -1.0, "smart": 1.7, "smile": 1.5, "sorry": -0.3, "spam": -1.5,
+	"stolen": -2.2, "strong": 2.3, "stupid": -2.4, "success": 2.7, "successful": 2.8,
+	"suck": -1.9, "sucks": -1.5, "super": 2.9, "support": 1.7, "sure": 1.3,
+	"terrible": -2.1, "thank": 1.5, "thanks": 1.9, "threat": -2.4, "tragic": -3.4,
+	"trouble": -1.7, "trust": 2.3, "ugly": -2.3, "unhappy": -1.8, "upset": -1.6,
+	"useful": 1.9, "useless": -1.8, "violence": -3.1, "war": -2.9, "waste": -1.8,
+	"weak": -1.9, "welcome": 2.0, "win": 2.8, "wonderful": 2.7, "worried": -1.2,
+	"worse": -2.1, "worst": -3.1, "wow": 2.8, "wrong": -2.1, "yay": 2.4,
+}
+
+// sentimentNegations flip the valence of the words which follow them.
+var sentimentNegations = map[string]bool{
+	"not": true, "no": true, "never": true, "none": true, "nobody": true, "nothing": true,
+	"neither": true, "nor": true, "without": true, "isn't": true, "aren't": true,
+	"wasn't": true, "weren't": true, "don't": true, "doesn't": true, "didn't": true,
+	"can't": true, "cannot": true, "won't": true, "wouldn't": true, "shouldn't": true,
+}
+
+const (
+	// sentimentNegationScope is how many following words a negation can
+	// apply to. It only applies to the first of them with a sentiment.
+	sentimentNegationScope = 3
+	// sentimentNegationFactor scales the valence of negated words.
+	sentimentNegationFactor = -0.74
+	// sentimentNeutral is the largest absolute score which is neutral.
+	sentimentNeutral = 0.05
+)
+
+// sentimentScore returns the sentiment of text from -1 (most negative) to 1
+// (most positive), by summing the valence of its words and normalising the
+// sum as VADER does. A word shortly after a negation counts against its usual
+// sentiment.
+func sentimentScore(text string) float64 {
+	var sum float64
+	negated := 0
+	for _, w := range tokenize(text) {
+		w = strings.ToLower(strings.TrimPrefix(w, "#"))
+		if sentimentNegations[w] {
+			negated = sentimentNegationScope
+			continue
+		}
+		if v, ok := sentimentLexicon[w]; ok {
+			if negated > 0 {
+				v *= sentimentNegationFactor
+			}
+			sum += v
+			negated = 0
+		} else if negated > 0 {
+			negated--
+		}
+	}
+	return sum / math.Sqrt(sum*sum+15)
+}
+
+// sentimentLabel returns the sentiment label of score.
+func sentimentLabel(score float64) string {
+	switch {
+	case score > sentimentNeutral:
+		return "positive"
+	case score < -sentimentNeutral:
+		return "negative"
+	default:
+		return "neutral"
+	}
+}
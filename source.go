@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// TweetSource is a backend which streams tweets to the exporter. Each source
+// is connected once, and a new one is created whenever the stream is
+// reopened.
+type TweetSource interface {
+	// Connect opens the stream described by c, notifying o about the state
+	// of its connection.
+	Connect(c twitterConfig, o streamObserver) error
+	// Messages returns the channel on which tweets and other messages are
+	// delivered. It's closed once the stream ends or is stopped.
+	Messages() <-chan interface{}
+	// Stop closes the stream.
+	Stop()
+}
+
+// statusReporter is implemented by sources which can report the HTTP status
+// of their last response, so that the reason a stream closed can be told.
+type statusReporter interface {
+	lastStatus() int
+}
+
+// tweetSources maps the names accepted by -source to constructors for each
+// source.
+var tweetSources = map[string]func() TweetSource{
+	"twitter":  func() TweetSource { return &twitterSource{} },
+	"mastodon": func() TweetSource { return &mastodonSource{} },
+	"bluesky":  func() TweetSource { return &blueskySource{} },
+	"file":     func() TweetSource { return &fileSource{} },
+}
+
+// newTweetSource returns an unconnected source with the given name.
+func newTweetSource(name string) (TweetSource, error) {
+	fn, ok := tweetSources[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown source %q, must be one of %s", name, strings.Join(tweetSourceNames(), ", "))
+	}
+	return fn(), nil
+}
+
+// tweetSourceNames returns the names of the available sources, sorted.
+func tweetSourceNames() []string {
+	var names []string
+	for name := range tweetSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// twitterSource streams from Twitter's filter or sample endpoint, or polls
+// the search API in poll mode.
+type twitterSource struct {
+	stream *twitter.Stream
+	st     *statusTransport
+	poll   *searchPoller
+}
+
+// Connect implements TweetSource, opening the stream selected by c.mode.
+func (s *twitterSource) Connect(c twitterConfig, o streamObserver) error {
+	if c.mode == "poll" {
+		s.poll = newSearchPoller(getHTTPClient(c), c, c.pollInterval, o)
+		return nil
+	}
+	// The recorder sits below the extended tweet rewriting, so that it
+	// records the messages exactly as Twitter sent them.
+	hc := authHTTPClient(c)
+	if rec := o.messageRecorder(); rec != nil {
+		hc.Transport = &recordTransport{next: hc.Transport, rec: rec}
+	}
+	hc.Transport = &extendedTweetTransport{next: hc.Transport}
+	s.st = &statusTransport{next: hc.Transport, observer: o, host: streamHost}
+	hc.Transport = s.st
+	client := twitter.NewClient(hc)
+
+	var err error
+	if c.mode == "sample" {
+		s.stream, err = client.Streams.Sample(&twitter.StreamSampleParams{
+			Language:      c.languages,
+			StallWarnings: twitter.Bool(true),
+		})
+	} else {
+		s.stream, err = client.Streams.Filter(&twitter.StreamFilterParams{
+			Track:         filterTerms(c.track),
+			Follow:        c.follow,
+			Locations:     locationParams(c.locations),
+			Language:      c.languages,
+			StallWarnings: twitter.Bool(true),
+		})
+	}
+	return err
+}
+
+// Messages implements TweetSource.
+func (s *twitterSource) Messages() <-chan interface{} {
+	if s.poll != nil {
+		return s.poll.msgs
+	}
+	return s.stream.Messages
+}
+
+// Stop implements TweetSource.
+func (s *twitterSource) Stop() {
+	if s.poll != nil {
+		s.poll.Stop()
+		return
+	}
+	s.stream.Stop()
+}
+
+// lastStatus implements statusReporter.
+func (s *twitterSource) lastStatus() int {
+	if s.poll != nil {
+		return s.poll.lastStatus()
+	}
+	return s.st.lastStatus()
+}
+
+// maxRecentIDs is the number of IDs remembered by recentIDs.
+const maxRecentIDs = 10000
+
+// recentIDs remembers the IDs of recently delivered tweets, so that sources
+// which may receive a tweet more than once only deliver it once.
+type recentIDs struct {
+	mtx  sync.Mutex
+	seen map[string]bool
+	ids  []string
+}
+
+func newRecentIDs() *recentIDs {
+	return &recentIDs{seen: map[string]bool{}}
+}
+
+// add records id, reporting whether it hadn't been seen before.
+func (r *recentIDs) add(id string) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.seen[id] {
+		return false
+	}
+	r.seen[id] = true
+	r.ids = append(r.ids, id)
+	if len(r.ids) > maxRecentIDs {
+		delete(r.seen, r.ids[0])
+		r.ids = r.ids[1:]
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// maxReconnectHistory is the number of reconnections kept for the status
+// API.
+const maxReconnectHistory = 20
+
+// reconnectEvent is a time the stream closed unexpectedly.
+type reconnectEvent struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	Error  string    `json:"error,omitempty"`
+	// Wait is how long the exporter waited before reconnecting.
+	Wait string `json:"wait"`
+}
+
+// recordReconnect adds ev to the reconnection history. streamMtx must be
+// held.
+func (e *Exporter) recordReconnect(ev reconnectEvent, err error) {
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	e.reconnectHistory = append(e.reconnectHistory, ev)
+	if len(e.reconnectHistory) > maxReconnectHistory {
+		e.reconnectHistory = e.reconnectHistory[len(e.reconnectHistory)-maxReconnectHistory:]
+	}
+}
+
+// statusResponse is the body of /api/v1/status.
+type statusResponse struct {
+	Build struct {
+		Version   string `json:"version"`
+		Commit    string `json:"commit"`
+		BuildDate string `json:"build_date"`
+		GoVersion string `json:"go_version"`
+	} `json:"build"`
+	StartTime time.Time `json:"start_time"`
+	Stream    struct {
+		Source       string     `json:"source"`
+		Connected    bool       `json:"connected"`
+		Paused       bool       `json:"paused"`
+		Ready        bool       `json:"ready"`
+		NotReady     string     `json:"not_ready_reason,omitempty"`
+		LastActivity *time.Time `json:"last_activity,omitempty"`
+		LastTweet    *time.Time `json:"last_tweet,omitempty"`
+	} `json:"stream"`
+	Keywords []string `json:"keywords"`
+	// Counts are totals since the exporter started.
+	Counts struct {
+		Tweets          float64            `json:"tweets"`
+		MatchedTweets   map[string]float64 `json:"matched_tweets"`
+		UnmatchedTweets float64            `json:"unmatched_tweets"`
+		Deletions       float64            `json:"deletions"`
+		ParseErrors     float64            `json:"parse_errors"`
+		Reconnects      float64            `json:"reconnects"`
+	} `json:"counts"`
+	Reconnects []reconnectEvent `json:"recent_reconnects"`
+}
+
+// statusHandler reports the exporter's state as JSON, for dashboards which
+// can't query Prometheus.
+func statusHandler(e *Exporter, maxSilence time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(e.status(maxSilence))
+	})
+}
+
+// status returns the exporter's current state.
+func (e *Exporter) status(maxSilence time.Duration) *statusResponse {
+	res := &statusResponse{StartTime: e.started.UTC(), Keywords: []string{}}
+	res.Build.Version, res.Build.Commit, res.Build.BuildDate = Version, CommitSHA1, BuildDate
+	res.Build.GoVersion = runtime.Version()
+
+	e.streamMtx.Lock()
+	res.Stream.Source = e.base.source
+	res.Reconnects = append([]reconnectEvent{}, e.reconnectHistory...)
+	e.streamMtx.Unlock()
+	res.Stream.Connected = atomic.LoadInt32(&e.isConnected) == 1
+	res.Stream.Paused = atomic.LoadInt32(&e.isPaused) == 1
+	if err := e.ready(maxSilence); err != nil {
+		res.Stream.NotReady = err.Error()
+	} else {
+		res.Stream.Ready = true
+	}
+	if t := atomic.LoadInt64(&e.lastActivity); t != 0 {
+		at := time.Unix(0, t).UTC()
+		res.Stream.LastActivity = &at
+	}
+	if t := atomic.LoadInt64(&e.lastTweet); t != 0 {
+		at := time.Unix(0, t).UTC()
+		res.Stream.LastTweet = &at
+	}
+
+	for k := range e.Keywords() {
+		res.Keywords = append(res.Keywords, k)
+	}
+	sort.Strings(res.Keywords)
+
+	res.Counts.Tweets, _ = counterTotal(e.tweetsProcessed, "")
+	_, res.Counts.MatchedTweets = counterTotal(e.matchedTweets, "keyword")
+	res.Counts.UnmatchedTweets, _ = counterTotal(e.unmatchedTweets, "")
+	res.Counts.Deletions, _ = counterTotal(e.deletions, "")
+	res.Counts.ParseErrors, _ = counterTotal(e.parseErrors, "")
+	res.Counts.Reconnects, _ = counterTotal(e.reconnects, "")
+	return res
+}
+
+// counterTotal returns the sum of the counters in c, and if label is given
+// their sums by the value of that label.
+func counterTotal(c prometheus.Collector, label string) (float64, map[string]float64) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	var total float64
+	byLabel := map[string]float64{}
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil || pb.Counter == nil {
+			continue
+		}
+		v := pb.Counter.GetValue()
+		total += v
+		for _, lp := range pb.Label {
+			if lp.GetName() == label {
+				byLabel[lp.GetValue()] += v
+			}
+		}
+	}
+	return total, byLabel
+}
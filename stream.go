@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// streamObserver is notified about the connections made for a stream.
+type streamObserver interface {
+	// setConnected is called with true when a stream response is received,
+	// and with false when it ends or a request fails.
+	setConnected(bool)
+	// streamActivity is called whenever data, including keep-alives, is
+	// read from the stream.
+	streamActivity()
+	// streamStatus is called with the status code of each response.
+	streamStatus(int)
+	// messageRecorder returns the recorder which raw messages should be
+	// archived to, or nil if they aren't being recorded.
+	messageRecorder() *recorder
+	// searchCursors returns how far search polling has got, so that a new
+	// poller carries on from where the last one stopped.
+	searchCursors() *searchCursors
+	// parseError is called when a message from the stream can't be
+	// decoded.
+	parseError()
+}
+
+// statusTransport records the status of the most recent response to a
+// streaming request. The vendored client retries some failures itself and
+// gives up on others without reporting why, so this is the only way to find
+// out what Twitter said.
+type statusTransport struct {
+	next     http.RoundTripper
+	observer streamObserver
+	// host is the host serving the stream. Requests to other hosts are
+	// passed through untouched.
+	host string
+
+	mtx    sync.Mutex
+	status int
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if req.URL.Host != t.host {
+		return resp, err
+	}
+	if err != nil {
+		t.observer.setConnected(false)
+		return resp, err
+	}
+	t.observer.streamStatus(resp.StatusCode)
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusSwitchingProtocols {
+		t.observer.setConnected(true)
+		resp.Body = &closeNotifier{
+			ReadCloser: resp.Body,
+			read:       t.observer.streamActivity,
+			fn:         func() { t.observer.setConnected(false) },
+		}
+	} else {
+		t.observer.setConnected(false)
+	}
+	t.mtx.Lock()
+	t.status = resp.StatusCode
+	t.mtx.Unlock()
+	return resp, nil
+}
+
+// lastStatus returns the status code of the most recent stream response, or
+// 0 if there hasn't been one.
+func (t *statusTransport) lastStatus() int {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.status
+}
+
+// closeNotifier calls read whenever data is read from a response body, and
+// fn once when it's closed or can no longer be read.
+type closeNotifier struct {
+	io.ReadCloser
+	read func()
+	once sync.Once
+	fn   func()
+}
+
+// Read implements io.Reader.
+func (c *closeNotifier) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		c.read()
+	}
+	if err != nil {
+		c.once.Do(c.fn)
+	}
+	return n, err
+}
+
+// Write implements io.Writer for upgraded and WebSocket connections.
+func (c *closeNotifier) Write(p []byte) (int, error) {
+	w, ok := c.ReadCloser.(io.Writer)
+	if !ok {
+		return 0, errors.New("response body is not writable")
+	}
+	return w.Write(p)
+}
+
+// Close implements io.Closer.
+func (c *closeNotifier) Close() error {
+	c.once.Do(c.fn)
+	return c.ReadCloser.Close()
+}
+
+// rateLimitWait returns how long Twitter asks clients to wait after the nth
+// consecutive rate-limited connection attempt. The vendored client follows
+// this schedule itself, with some jitter.
+func rateLimitWait(n int32) time.Duration {
+	if n < 1 {
+		return 0
+	}
+	if n > 5 {
+		return 16 * time.Minute
+	}
+	return time.Minute << uint(n-1)
+}
+
+// streamStatus implements streamObserver, tracking rate-limited connection
+// attempts.
+func (e *Exporter) streamStatus(code int) {
+	switch code {
+	case 420, http.StatusTooManyRequests:
+		n := atomic.AddInt32(&e.rateLimitAttempts, 1)
+		wait := rateLimitWait(n)
+		e.rateLimited.Inc()
+		e.rateLimitBackoff.Set(wait.Seconds())
+		logWarn("Connection rate limited by Twitter", "code", code, "wait", wait)
+	case http.StatusOK, http.StatusSwitchingProtocols:
+		atomic.StoreInt32(&e.rateLimitAttempts, 0)
+		e.rateLimitBackoff.Set(0)
+	}
+}
+
+// newBackOff returns an exponential backoff with jitter which never gives up.
+func newBackOff(initial, max time.Duration) *backoff.ExponentialBackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = initial
+	b.Multiplier = 2
+	b.MaxInterval = max
+	b.MaxElapsedTime = 0
+	b.Reset()
+	return b
+}
+
+// reconnectBackOffs follow Twitter's guidance for reconnecting after network
+// errors, HTTP errors and rate limiting respectively.
+// https://dev.twitter.com/streaming/overview/connecting
+type reconnectBackOffs struct {
+	network, http, rateLimit *backoff.ExponentialBackOff
+}
+
+func newReconnectBackOffs() *reconnectBackOffs {
+	return &reconnectBackOffs{
+		network:   newBackOff(250*time.Millisecond, 16*time.Second),
+		http:      newBackOff(5*time.Second, 320*time.Second),
+		rateLimit: newBackOff(time.Minute, 16*time.Minute),
+	}
+}
+
+// next returns how long to wait before reconnecting after a stream closed
+// for reason.
+func (b *reconnectBackOffs) next(reason string) time.Duration {
+	switch reason {
+	case "network_error":
+		return b.network.NextBackOff()
+	case "http_420", "http_429":
+		return b.rateLimit.NextBackOff()
+	default:
+		return b.http.NextBackOff()
+	}
+}
+
+func (b *reconnectBackOffs) reset() {
+	b.network.Reset()
+	b.http.Reset()
+	b.rateLimit.Reset()
+}
+
+// closeReason describes why a stream closed, given the last error it
+// delivered and the status of its last response.
+func closeReason(err error, status int) string {
+	switch {
+	case err != nil:
+		return "network_error"
+	case status != 0 && status != http.StatusOK && status != http.StatusSwitchingProtocols:
+		return fmt.Sprintf("http_%d", status)
+	default:
+		return "closed"
+	}
+}
+
+// streamClosed is called when the messages channel of s has been closed. If
+// s is still the current stream it wasn't stopped deliberately, so a new
+// stream is opened after backing off.
+func (e *Exporter) streamClosed(s TweetSource, received bool, err error) {
+	e.streamMtx.Lock()
+	defer e.streamMtx.Unlock()
+	if e.stream != s {
+		return
+	}
+	e.stream = nil
+
+	if received {
+		e.backoffs.reset()
+	}
+	var status int
+	if sr, ok := s.(statusReporter); ok {
+		status = sr.lastStatus()
+	}
+	reason := closeReason(err, status)
+	wait := e.backoffs.next(reason)
+	e.reconnects.WithLabelValues(e.series.values("twitter_stream_reconnects_total", reason)...).Inc()
+	e.recordReconnect(reconnectEvent{Time: time.Now().UTC(), Reason: reason, Wait: wait.String()}, err)
+	if reason == "http_420" || reason == "http_429" {
+		e.rateLimitBackoff.Set(wait.Seconds())
+	}
+	if err != nil {
+		logWarn("Stream closed", "reason", reason, "err", err, "wait", wait)
+	} else {
+		logWarn("Stream closed", "reason", reason, "wait", wait)
+	}
+	e.retryTimer = time.AfterFunc(wait, e.reconnect)
+}
+
+// reconnect opens a new stream unless one has been opened in the meantime or
+// the exporter has been stopped.
+func (e *Exporter) reconnect() {
+	e.streamMtx.Lock()
+	defer e.streamMtx.Unlock()
+	if e.stream != nil || e.stopped {
+		return
+	}
+	if err := e.restart(); err != nil {
+		logError("Error reconnecting to stream", "err", err)
+	}
+}
+
+// watchIdle restarts the stream whenever it's been connected for longer than
+// timeout without receiving any data. If the restart fails, restart retries it
+// after a backoff. Twitter sends keep-alives every 30
+// seconds, so a silent stream is most likely a half-open connection which
+// will never deliver anything.
+func (e *Exporter) watchIdle(timeout time.Duration) {
+	for range time.Tick(timeout / 10) {
+		e.streamMtx.Lock()
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&e.lastActivity)))
+		if e.stream != nil && !e.stopped && atomic.LoadInt32(&e.isConnected) == 1 && idle > timeout {
+			logWarn("No data received from the stream, restarting it", "idle", idle)
+			e.watchdogRestarts.Inc()
+			if err := e.restart(); err != nil {
+				logError("Error restarting stream", "err", err)
+			}
+		}
+		e.streamMtx.Unlock()
+	}
+}
+
+// messageType names the type of a message from a stream for the type label
+// of twitter_stream_exporter_messages_received_total.
+func messageType(msg interface{}) string {
+	switch m := msg.(type) {
+	case *twitter.Tweet:
+		return "tweet"
+	case *twitter.StatusDeletion:
+		return "delete"
+	case *twitter.LocationDeletion:
+		return "scrub_geo"
+	case *twitter.StreamLimit:
+		return "limit"
+	case *twitter.StatusWithheld:
+		return "status_withheld"
+	case *twitter.UserWithheld:
+		return "user_withheld"
+	case *twitter.StreamDisconnect:
+		return "disconnect"
+	case *twitter.StallWarning:
+		return "warning"
+	case *twitter.DirectMessage, *twitter.FriendsList, *twitter.Event:
+		return "user_stream"
+	case error:
+		if isParseError(m) {
+			return "invalid"
+		}
+		return "error"
+	}
+	return "other"
+}
+
+// isParseError reports whether err came from decoding a message rather than
+// reading the stream.
+func isParseError(err error) bool {
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// systemd passes activated sockets starting from this file descriptor.
+const listenFDsStart = 3
+
+// systemdListener returns the first socket passed by systemd socket
+// activation. The LISTEN_* variables are cleared so that child processes
+// don't also try to use them.
+// https://www.freedesktop.org/software/systemd/man/sd_listen_fds.html
+func systemdListener() (net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, fmt.Errorf("no sockets were passed by systemd; check that the unit has a matching .socket unit")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("no sockets were passed by systemd; check that the unit has a matching .socket unit")
+	}
+	if n > 1 {
+		logWarn("systemd passed several sockets, only the first is used", "count", n)
+	}
+	f := os.NewFile(listenFDsStart, "LISTEN_FD_3")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// sdNotify sends state, such as READY=1, to systemd's notification socket.
+// It does nothing unless the exporter was started by a unit with
+// Type=notify or a watchdog.
+// https://www.freedesktop.org/software/systemd/man/sd_notify.html
+func sdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	if name[0] == '@' {
+		// An abstract socket.
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// systemdWatchdogInterval returns how often systemd expects watchdog pings,
+// or zero if the watchdog isn't enabled for this process.
+func systemdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// feedWatchdog pings systemd's watchdog at half its interval for as long as
+// the stream is ready or deliberately paused, so that systemd restarts the
+// exporter if the stream stays unhealthy for longer than WatchdogSec. The
+// reason is shown in systemctl status while it isn't ready.
+func (e *Exporter) feedWatchdog(interval, maxSilence time.Duration) {
+	ready := true
+	for range time.Tick(interval / 2) {
+		err := e.ready(maxSilence)
+		if err == errStreamPaused {
+			sdNotify("WATCHDOG=1\nSTATUS=Paused")
+			err = nil
+		} else if err != nil {
+			if ready {
+				logWarn("Withholding systemd watchdog pings while the stream isn't ready", "err", err)
+			}
+			sdNotify("STATUS=Not ready: " + err.Error())
+		} else {
+			sdNotify("WATCHDOG=1\nSTATUS=Streaming")
+		}
+		ready = err == nil
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// Limits on /debug/tail, which is for spot checks rather than consuming the
+// stream: at most maxTailClients may be connected, each receiving at most
+// tailRate tweets a second. Tweets beyond that are skipped and counted.
+const (
+	maxTailClients = 2
+	tailRate       = 10
+	tailBuffer     = 100
+)
+
+// tailEvent is the summary of a processed tweet sent to /debug/tail. It
+// leaves out the text and everything else about the author.
+type tailEvent struct {
+	ID       string   `json:"id"`
+	Author   string   `json:"author"`
+	Keywords []string `json:"keywords"`
+	// Skipped is the number of tweets skipped since the previous event to
+	// keep to the rate limit.
+	Skipped int `json:"skipped,omitempty"`
+}
+
+func newTailEvent(t *twitter.Tweet, matched map[string]bool) tailEvent {
+	ev := tailEvent{ID: t.IDStr, Keywords: []string{}}
+	if t.User != nil {
+		ev.Author = t.User.ScreenName
+	}
+	for kw := range matched {
+		ev.Keywords = append(ev.Keywords, kw)
+	}
+	sort.Strings(ev.Keywords)
+	return ev
+}
+
+// tailBroker fans processed tweets out to the /debug/tail clients.
+type tailBroker struct {
+	// clients is the number of connected clients, so that tweets aren't
+	// summarised when nobody is listening. It's accessed atomically.
+	clients int32
+
+	mtx    sync.Mutex
+	subs   map[chan tailEvent]bool
+	closed bool
+}
+
+func newTailBroker() *tailBroker {
+	return &tailBroker{subs: map[chan tailEvent]bool{}}
+}
+
+// active reports whether any clients are connected.
+func (b *tailBroker) active() bool {
+	return atomic.LoadInt32(&b.clients) > 0
+}
+
+// publish sends ev to each client, dropping it for clients which are
+// falling behind.
+func (b *tailBroker) publish(ev tailEvent) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel of events for a new client, or false if the
+// limit on clients has been reached or the broker is closed.
+func (b *tailBroker) subscribe() (chan tailEvent, bool) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.closed || len(b.subs) >= maxTailClients {
+		return nil, false
+	}
+	ch := make(chan tailEvent, tailBuffer)
+	b.subs[ch] = true
+	atomic.AddInt32(&b.clients, 1)
+	return ch, true
+}
+
+func (b *tailBroker) unsubscribe(ch chan tailEvent) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.subs[ch] {
+		delete(b.subs, ch)
+		close(ch)
+		atomic.AddInt32(&b.clients, -1)
+	}
+}
+
+// close disconnects every client, so that they don't hold up the HTTP
+// server's shutdown.
+func (b *tailBroker) close() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+		atomic.AddInt32(&b.clients, -1)
+	}
+}
+
+// tailHandler streams a summary of each processed tweet as server-sent
+// events. It requires the reload token, as the keyword API does.
+func tailHandler(b *tailBroker, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Only GET requests are allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if token == "" {
+			http.Error(w, fmt.Sprintf("Tweets can't be tailed unless %s is set", envReloadToken), http.StatusForbidden)
+			return
+		}
+		if !authorized(w, r, token) {
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+		ch, ok := b.subscribe()
+		if !ok {
+			http.Error(w, fmt.Sprintf("At most %d clients may tail tweets at once", maxTailClients), http.StatusTooManyRequests)
+			return
+		}
+		defer b.unsubscribe(ch)
+
+		logInfo("Tailing tweets", "remote_addr", r.RemoteAddr)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		var sent []time.Time
+		skipped := 0
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case ev, ok := <-ch:
+				if !ok {
+					return
+				}
+				now := time.Now()
+				for len(sent) > 0 && now.Sub(sent[0]) >= time.Second {
+					sent = sent[1:]
+				}
+				if len(sent) >= tailRate {
+					skipped++
+					continue
+				}
+				sent = append(sent, now)
+				ev.Skipped, skipped = skipped, 0
+				data, _ := json.Marshal(ev)
+				if _, err := fmt.Fprintf(w, "event: tweet\ndata: %s\n\n", data); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// trackFileSettle is how long the keyword file's directory must go without
+// changes before the file is re-read, so that an editor saving it in several
+// steps causes a single restart.
+const trackFileSettle = 100 * time.Millisecond
+
+// readTrackFile reads keywords from path, one per line. Blank lines and
+// comments, which are lines starting with '#' followed by a space or nothing
+// else, are ignored. Hashtags such as #golang are keywords.
+func readTrackFile(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kw []string
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		l := strings.TrimSpace(sc.Text())
+		if l == "" || l == "#" || strings.HasPrefix(l, "# ") || strings.HasPrefix(l, "#\t") {
+			continue
+		}
+		kw = append(kw, l)
+	}
+	return kw, sc.Err()
+}
+
+// watchTrackFile watches path with fsnotify and calls onChange whenever its
+// contents differ from the last time it was read. The directory is watched
+// rather than the file, so that replacing the file, as editors and Kubernetes'
+// ConfigMap volumes do, is noticed as well as editing it in place. It only
+// returns if the file can't be watched.
+func watchTrackFile(path string, onChange func()) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		logError("Error watching file, changes to it need a reload", "path", path, "err", err)
+		return
+	}
+	defer w.Close()
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		logError("Error watching file, changes to it need a reload", "path", path, "err", err)
+		return
+	}
+
+	last, err := ioutil.ReadFile(path)
+	if err != nil {
+		logError("Error reading file", "path", path, "err", err)
+	}
+	var settle <-chan time.Time
+	for {
+		select {
+		case _, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			// Events for other files in the directory are rare, and
+			// comparing the contents ignores them.
+			settle = time.After(trackFileSettle)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			logError("Error watching file", "path", path, "err", err)
+		case <-settle:
+			settle = nil
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				// It's most likely been removed, and will be read again
+				// when it's recreated.
+				logError("Error reading file", "path", path, "err", err)
+				continue
+			}
+			if bytes.Equal(b, last) {
+				continue
+			}
+			last = b
+			logInfo("File has changed", "path", path)
+			onChange()
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadTrackFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackfile_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keywords.txt")
+	if err := ioutil.WriteFile(path, []byte("# Languages\n#golang\n\n  rustlang  \n#\n#\tdisabled\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	kw, err := readTrackFile(path)
+	if err != nil {
+		t.Fatalf("readTrackFile: %v", err)
+	}
+	if want := []string{"#golang", "rustlang"}; !reflect.DeepEqual(kw, want) {
+		t.Errorf("got keywords %q, want %q", kw, want)
+	}
+}
+
+func TestWatchTrackFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trackfile_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Kubernetes mounts ConfigMaps as symlinks to a ..data symlink, which is
+	// replaced to point at a new directory when the ConfigMap changes.
+	for _, d := range []string{"v1", "v2"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, d, "keywords.txt"), []byte(d+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "keywords.txt")
+	if err := os.Symlink(filepath.Join("..data", "keywords.txt"), path); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := make(chan struct{}, 10)
+	go watchTrackFile(path, func() { changed <- struct{}{} })
+	// Give the watcher time to start.
+	time.Sleep(100 * time.Millisecond)
+
+	expect := func(what string, want bool) {
+		t.Helper()
+		select {
+		case <-changed:
+			if !want {
+				t.Errorf("%s: got a change", what)
+			}
+		case <-time.After(time.Second):
+			if want {
+				t.Errorf("%s: got no change", what)
+			}
+		}
+	}
+
+	if err := os.Symlink("v2", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	expect("replacing the ConfigMap", true)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "v2", "keywords.txt"), []byte("v2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "other.txt"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	expect("writing the same keywords", false)
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("v3\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	expect("replacing the file", true)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("v4\n")
+	f.Close()
+	expect("editing the file in place", true)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Dimensions of the count-min sketch used to estimate hashtag counts. With
+// these the estimates exceed the true counts by at most 0.13% of the total
+// with 98% confidence, in 64KiB.
+const (
+	sketchDepth = 4
+	sketchWidth = 2048
+)
+
+// topK estimates the k most frequent hashtags in a stream, in memory bounded
+// by k rather than the number of distinct hashtags. Counts are kept in a
+// count-min sketch and the current leaders in a min-heap, and both decay
+// exponentially so that the leaders reflect recent activity.
+type topK struct {
+	k        int
+	halfLife time.Duration
+
+	mtx      sync.Mutex
+	sketch   [sketchDepth][sketchWidth]float64
+	leaders  topKHeap
+	index    map[string]*topKEntry
+	lastHalf time.Time
+}
+
+// topKEntry is a hashtag among the current leaders.
+type topKEntry struct {
+	tag   string
+	count float64
+	i     int
+}
+
+// newTopK returns a tracker for the k most frequent hashtags, whose counts
+// halve every halfLife.
+func newTopK(k int, halfLife time.Duration) *topK {
+	return &topK{k: k, halfLife: halfLife, index: map[string]*topKEntry{}, lastHalf: time.Now()}
+}
+
+// add counts an occurrence of tag.
+func (t *topK) add(tag string) {
+	tag = strings.ToLower(tag)
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.decay()
+
+	h := fnv.New64a()
+	h.Write([]byte(tag))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+	est := math.Inf(1)
+	for i := range t.sketch {
+		cell := &t.sketch[i][(h1+uint64(i)*h2)%sketchWidth]
+		*cell++
+		est = math.Min(est, *cell)
+	}
+
+	if e, ok := t.index[tag]; ok {
+		e.count = est
+		heap.Fix(&t.leaders, e.i)
+		return
+	}
+	if len(t.leaders) < t.k {
+		e := &topKEntry{tag: tag, count: est}
+		heap.Push(&t.leaders, e)
+		t.index[tag] = e
+		return
+	}
+	if min := t.leaders[0]; est > min.count {
+		delete(t.index, min.tag)
+		min.tag, min.count = tag, est
+		t.index[tag] = min
+		heap.Fix(&t.leaders, 0)
+	}
+}
+
+// decay halves every count once for each half-life which has passed since
+// they were last halved. t.mtx must be held.
+func (t *topK) decay() {
+	n := int(time.Since(t.lastHalf) / t.halfLife)
+	if n == 0 {
+		return
+	}
+	t.lastHalf = t.lastHalf.Add(time.Duration(n) * t.halfLife)
+	f := math.Pow(0.5, float64(n))
+	for i := range t.sketch {
+		for j := range t.sketch[i] {
+			t.sketch[i][j] *= f
+		}
+	}
+	for _, e := range t.leaders {
+		e.count *= f
+	}
+}
+
+// top returns the current leaders, most frequent first.
+func (t *topK) top() []topKEntry {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.decay()
+	l := make([]topKEntry, 0, len(t.leaders))
+	for _, e := range t.leaders {
+		l = append(l, *e)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].count > l[j].count })
+	return l
+}
+
+// topKHeap is a min-heap of entries ordered by count.
+type topKHeap []*topKEntry
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].i = i
+	h[j].i = j
+}
+
+func (h *topKHeap) Push(x interface{}) {
+	e := x.(*topKEntry)
+	e.i = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
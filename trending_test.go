@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTopK(t *testing.T) {
+	for _, tt := range []struct {
+		k     int
+		heavy int
+		noise int
+	}{
+		{k: 5, heavy: 5, noise: 0},
+		{k: 5, heavy: 10, noise: 100},
+		{k: 10, heavy: 10, noise: 5000},
+		{k: 10, heavy: 20, noise: 50000},
+	} {
+		// Heavy hitter i occurs 50*(i+1) times and each noise tag once, in
+		// interleaved order so that the leaders change as the counts grow.
+		tk := newTopK(tt.k, time.Hour)
+		counts := map[string]int{}
+		total := 0
+		for round, noise := 0, 0; ; round++ {
+			added := false
+			for i := 0; i < tt.heavy; i++ {
+				if round < 50*(i+1) {
+					tag := "heavy" + strconv.Itoa(i)
+					tk.add(tag)
+					counts[tag]++
+					total++
+					added = true
+				}
+			}
+			for j := 0; j < 10 && noise < tt.noise; j, noise = j+1, noise+1 {
+				tk.add("noise" + strconv.Itoa(noise))
+				total++
+				added = true
+			}
+			if !added {
+				break
+			}
+		}
+
+		// A count-min sketch never underestimates, and overestimates by at
+		// most e/width of the total with high probability.
+		bound := math.E / sketchWidth * float64(total)
+		top := tk.top()
+		if len(top) != tt.k {
+			t.Fatalf("k=%d, %d heavy, %d noise: got %d leaders, want %d", tt.k, tt.heavy, tt.noise, len(top), tt.k)
+		}
+		for i, e := range top {
+			want := "heavy" + strconv.Itoa(tt.heavy-1-i)
+			if e.tag != want {
+				t.Errorf("k=%d, %d heavy, %d noise: leader %d is %q, want %q", tt.k, tt.heavy, tt.noise, i, e.tag, want)
+				continue
+			}
+			if n := float64(counts[e.tag]); e.count < n || e.count > n+bound {
+				t.Errorf("k=%d, %d heavy, %d noise: %s has estimate %.0f, want %.0f to %.0f", tt.k, tt.heavy, tt.noise, e.tag, e.count, n, n+bound)
+			}
+		}
+	}
+}
+
+func TestTopKCaseInsensitive(t *testing.T) {
+	tk := newTopK(1, time.Hour)
+	for _, tag := range []string{"golang", "GoLang", "GOLANG"} {
+		tk.add(tag)
+	}
+	if top := tk.top(); len(top) != 1 || top[0].tag != "golang" || top[0].count != 3 {
+		t.Errorf("got leaders %+v, want golang with 3", top)
+	}
+}
+
+func TestTopKDecay(t *testing.T) {
+	tk := newTopK(2, time.Minute)
+	for i := 0; i < 80; i++ {
+		tk.add("old")
+	}
+	// Two half-lives pass before the next hashtags arrive.
+	tk.lastHalf = tk.lastHalf.Add(-2*time.Minute - time.Second)
+	for i := 0; i < 30; i++ {
+		tk.add("new")
+	}
+	top := tk.top()
+	if len(top) != 2 || top[0].tag != "new" || top[0].count != 30 || top[1].tag != "old" || top[1].count != 20 {
+		t.Errorf("got leaders %+v, want new with 30 then old with 20", top)
+	}
+}
@@ -1,19 +1,32 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
-	"log"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
+	"unicode/utf8"
 
 	"github.com/dghubble/go-twitter/twitter"
 	"github.com/dghubble/oauth1"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -21,6 +34,11 @@ const (
 	envAccessSecret   = "TWITTER_ACCESS_SECRET"
 	envConsumerKey    = "TWITTER_CONSUMER_KEY"
 	envConsumerSecret = "TWITTER_CONSUMER_SECRET"
+	envBearerToken    = "TWITTER_BEARER_TOKEN"
+	envMastodonToken  = "MASTODON_ACCESS_TOKEN"
+	envBlueskyID      = "BLUESKY_IDENTIFIER"
+	envBlueskyPass    = "BLUESKY_APP_PASSWORD"
+	envReloadToken    = "TWITTER_STREAM_EXPORTER_RELOAD_TOKEN"
 )
 
 var (
@@ -32,97 +50,1414 @@ var (
 	CommitSHA1 = "UNKNOWN"
 )
 
+// printVersion writes the build information to stdout in the given format,
+// returning the process's exit code.
+func printVersion(format string) int {
+	switch format {
+	case "text":
+		fmt.Printf("twitter_stream_exporter %s (build date: %s) (sha1: %s) (go: %s)\n", Version, BuildDate, CommitSHA1, runtime.Version())
+	case "json":
+		json.NewEncoder(os.Stdout).Encode(map[string]string{
+			"version":        Version,
+			"commit_sha":     CommitSHA1,
+			"build_date":     BuildDate,
+			"golang_version": runtime.Version(),
+		})
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown version format %q, must be text or json\n", format)
+		return 1
+	}
+	return 0
+}
+
 // twitterConfig contains the arguments necessary to connect to the streaming API.
 type twitterConfig struct {
 	accessToken    string
 	tokenSecret    string
 	consumerKey    string
 	consumerSecret string
-	track          []string
+	// bearerToken is used for app-only authentication instead of the four
+	// oauth1 values when it's set.
+	bearerToken string
+	// proxyURL is the proxy used to connect to Twitter, if any.
+	proxyURL *url.URL
+	// source is "twitter", "mastodon" to stream statuses from the instance
+	// at mastodonURL, or "bluesky" to stream posts from the firehose at
+	// blueskyURL.
+	source            string
+	mastodonURL       string
+	mastodonToken     string
+	blueskyURL        string
+	blueskyPDS        string
+	blueskyIdentifier string
+	blueskyPassword   string
+	// startPaused starts the exporter without opening the stream, until
+	// it's resumed through the API.
+	startPaused bool
+	// sourceFile is replayed at sourceRate tweets per second when source is
+	// "file", or as fast as possible if sourceRate is zero.
+	sourceFile string
+	sourceRate float64
+	// mode is "filter" to stream tweets matching track, "sample" to
+	// stream a sample of all public tweets, or "poll" to search for tweets
+	// matching track every pollInterval.
+	mode         string
+	pollInterval time.Duration
+	track        []string
+	// groups maps group names to the keywords they contain. Every grouped
+	// keyword also appears in track.
+	groups map[string][]string
+	// aliases maps canonical keywords to variant spellings which should be
+	// counted under the canonical keyword's label.
+	aliases map[string][]string
+	// exclude lists terms which cause a tweet to be ignored entirely.
+	exclude []string
+	// follow lists the IDs of users whose tweets are streamed in addition
+	// to those matching track.
+	follow []string
+	// listID is a list whose members are also followed.
+	listID string
+	// followNames maps the IDs of followed list members to the screen
+	// names they're labelled with.
+	followNames map[string]string
+	// locations are bounding boxes whose tweets are streamed in addition to
+	// those matching track.
+	locations []geoBox
+	// languages restricts the stream to tweets in these BCP 47 languages.
+	languages []string
+	// keywords holds matching options for individual keywords.
+	keywords []keywordOptions
+	// foldDiacritics ignores accents when matching keywords.
+	foldDiacritics bool
+	// countQuoted also matches keywords in the tweets which are quoted.
+	countQuoted bool
+	// sampleRate is the fraction of received tweets which are processed.
+	sampleRate float64
+	// logSampleMatches is n to log every nth tweet matching a keyword, or 0
+	// to log none of them.
+	logSampleMatches int
+	// hashtagPairs enables counting the hashtags which appear alongside
+	// tracked keywords, limited to hashtagPairsAllow if that's set and
+	// otherwise to hashtagPairsMax distinct hashtags.
+	hashtagPairs      bool
+	hashtagPairsMax   int
+	hashtagPairsAllow []string
+	// trendingHashtags is the number of untracked hashtags to export as
+	// trending, with counts halving every trendingHalfLife.
+	trendingHashtags int
+	trendingHalfLife time.Duration
+	// linkDomains enables counting the domains linked to by matching
+	// tweets, excluding linkDomainsDeny and limited to linkDomainsAllow if
+	// that's set and otherwise to linkDomainsMax distinct domains.
+	linkDomains      bool
+	linkDomainsMax   int
+	linkDomainsAllow []string
+	linkDomainsDeny  []string
+	// clientApps enables counting the applications matching tweets were
+	// posted with, limited to clientAppsAllow if that's set and otherwise to
+	// clientAppsMax distinct applications.
+	clientApps      bool
+	clientAppsMax   int
+	clientAppsAllow []string
+	// sentiment enables scoring the sentiment of matching tweets.
+	sentiment bool
+	// profileMentions enables matching keywords in the profiles of the
+	// authors of tweets.
+	profileMentions bool
+	// emoji enables counting the emoji in matching tweets, limited to
+	// emojiAllow if that's set and otherwise to emojiMax distinct emoji.
+	emoji      bool
+	emojiMax   int
+	emojiAllow []string
+	// uniqueAuthors enables estimating the number of distinct authors of
+	// the tweets matching each keyword.
+	uniqueAuthors bool
+	// botHeuristics enables counting tweets by suspected bots, whose
+	// authors meet at least botMinSignals of the heuristics in bots.go.
+	botHeuristics       bool
+	botMinSignals       int
+	botMaxAccountAge    time.Duration
+	botMinFollowerRatio float64
+	botSources          []string
+	// duplicates enables counting tweets whose text nearly duplicates
+	// another's within duplicatesWindow, and duplicatesExclude leaves them
+	// out of the mention counters.
+	duplicates        bool
+	duplicatesWindow  time.Duration
+	duplicatesExclude bool
+	// classifierURL is the service matching tweets are sent to for
+	// labelling, if any, with up to classifierConcurrency requests in
+	// flight, each allowed classifierTimeout. At most classifierMaxLabels
+	// distinct labels are exported.
+	classifierURL         string
+	classifierTimeout     time.Duration
+	classifierConcurrency int
+	classifierMaxLabels   int
 }
 
 // getTwitterClient does the oauth dance and returns a Twitter client.
 func getTwitterClient(c twitterConfig) *twitter.Client {
-	oc := oauth1.NewConfig(c.consumerKey, c.consumerSecret)
-	ot := oauth1.NewToken(c.accessToken, c.tokenSecret)
-	hc := oc.Client(oauth1.NoContext, ot)
-	return twitter.NewClient(hc)
+	return twitter.NewClient(getHTTPClient(c))
+}
+
+// getHTTPClient returns an HTTP client which authenticates requests to the
+// Twitter API and asks for extended tweets.
+func getHTTPClient(c twitterConfig) *http.Client {
+	hc := authHTTPClient(c)
+	hc.Transport = &extendedTweetTransport{next: hc.Transport}
+	return hc
+}
+
+// authHTTPClient returns an HTTP client which authenticates requests to the
+// Twitter API, leaving the responses as Twitter sent them.
+func authHTTPClient(c twitterConfig) *http.Client {
+	base := proxyTransport(c.proxyURL)
+	var hc *http.Client
+	if c.bearerToken != "" {
+		hc = &http.Client{Transport: &bearerTransport{token: c.bearerToken, next: base}}
+	} else {
+		oc := oauth1.NewConfig(c.consumerKey, c.consumerSecret)
+		ot := oauth1.NewToken(c.accessToken, c.tokenSecret)
+		ctx := context.WithValue(oauth1.NoContext, oauth1.HTTPClient, &http.Client{Transport: base})
+		hc = oc.Client(ctx, ot)
+	}
+	return hc
+}
+
+// bearerTransport authenticates requests with an OAuth2 app-only bearer token.
+type bearerTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "Bearer "+t.token)
+	return t.next.RoundTrip(r)
+}
+
+// testAuth checks the configured credentials against the Twitter API and
+// prints the account they belong to, returning the process's exit code.
+func testAuth() int {
+	c, err := buildConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if errs := c.twitter.validateCredentials(); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return 1
+	}
+	switch c.twitter.source {
+	case "mastodon":
+		return testMastodonAuth(c.twitter)
+	case "bluesky":
+		return testBlueskyAuth(c.twitter)
+	}
+
+	endpoint := "verify_credentials"
+	if c.twitter.bearerToken != "" {
+		endpoint = "search"
+	}
+	u, resp, err := verifyCredentials(c.twitter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if u != nil {
+		fmt.Printf("Authenticated as @%s (%s)\n", u.ScreenName, u.Name)
+	} else {
+		fmt.Println("Authenticated with an app-only bearer token")
+	}
+	if remaining := resp.Header.Get("X-Rate-Limit-Remaining"); remaining != "" {
+		reset := resp.Header.Get("X-Rate-Limit-Reset")
+		if secs, err := strconv.ParseInt(reset, 10, 64); err == nil {
+			reset = time.Unix(secs, 0).Format(time.RFC3339)
+		}
+		fmt.Printf("%s of %s %s requests remaining, resetting at %s\n",
+			remaining, resp.Header.Get("X-Rate-Limit-Limit"), endpoint, reset)
+	}
+	return 0
 }
 
 // Exporter collects metrics from the Twitter API.
 type Exporter struct {
-	stream   *twitter.Stream
-	keywords map[string]bool
+	// streamMtx serialises starting and stopping the stream.
+	streamMtx sync.Mutex
+	stream    TweetSource
+	// handlers tracks the goroutines processing each stream's messages, so
+	// that shutdown can wait for them to finish.
+	handlers sync.WaitGroup
+	// base is the configuration given to Reload, before keywords added and
+	// removed through the API are applied. It's guarded by streamMtx.
+	base    twitterConfig
+	added   []string
+	removed map[string]bool
+	// stopped is set once Stop has been called, and prevents the stream
+	// from being reopened.
+	stopped    bool
+	retryTimer *time.Timer
+	backoffs   *reconnectBackOffs
+	// reconnectHistory holds the most recent times the stream closed
+	// unexpectedly, oldest first. It's guarded by streamMtx.
+	reconnectHistory []reconnectEvent
+	started          time.Time
+
+	// lastActivity is the time in Unix nanoseconds at which data was last
+	// read from the stream, lastTweet that at which a tweet was last
+	// received, and isConnected is 1 while it's connected. All are accessed
+	// atomically.
+	lastActivity int64
+	lastTweet    int64
+	isConnected  int32
+	// isPaused is 1 while the stream has been paused through the API. It's
+	// only changed with streamMtx held.
+	isPaused int32
+	// matchCount counts the tweets matching keywords, for sampling them
+	// to the log, and is accessed atomically.
+	matchCount uint64
+	// rateLimitAttempts counts consecutive rate-limited connection
+	// attempts, and is accessed atomically.
+	rateLimitAttempts int32
+	// listMembers holds the screen names of the members of the followed
+	// list, indexed by user ID.
+	listMembers map[string]string
+	// rec archives raw messages from the stream, if recording is enabled.
+	rec *recorder
+	// cursors holds how far search polling has got across reconnects.
+	cursors *searchCursors
+
+	mtx      sync.RWMutex
+	keywords map[string]keyword
+	matcher  *matcher
+	exclude  map[string]bool
+	follow   map[string]string
+	boxes    []geoBox
+	langs    map[string]bool
+	quoted   bool
+	// logMatches is n to log every nth matching tweet, or 0.
+	logMatches int
+	scored     bool
+	profiled   bool
+	cl         *classifier
+	rate       float64
+	// replaying is set while tweets are replayed from a file, whose age
+	// says nothing about the delivery lag.
+	replaying bool
+	pairs     *labelLimit
+	trending  *topK
+	domains   *labelLimit
+	apps      *labelLimit
+	emojis    *labelLimit
+	uniques   *authorCounter
+	bots      *botDetector
+	// backlog buffers the messages of the current stream which haven't
+	// been handled yet.
+	backlog chan interface{}
+	dupes   *duplicateFilter
+	// rates isn't replaced on reconnection, so needs no lock.
+	rates *keywordRates
+	// series caps the label combinations of each metric.
+	series *seriesLimit
+	// tail sends processed tweets to /debug/tail clients.
+	tail *tailBroker
+
+	matchingTweets  *prometheus.CounterVec
+	excludedTweets  *prometheus.CounterVec
+	languageTweets  *prometheus.CounterVec
+	followedTweets  *prometheus.CounterVec
+	geoTweets       *prometheus.CounterVec
+	replies         *prometheus.CounterVec
+	retweetedUsers  *prometheus.CounterVec
+	tagMentions     *prometheus.CounterVec
+	userMentions    *prometheus.CounterVec
+	wordMentions    *prometheus.CounterVec
+	cashMentions    *prometheus.CounterVec
+	keywordMatches  *prometheus.CounterVec
+	matchedTweets   *prometheus.CounterVec
+	unmatchedTweets *prometheus.CounterVec
+
+	multiKeywordTweets *prometheus.CounterVec
+	suspectedBots      *prometheus.CounterVec
+	duplicateTweets    *prometheus.CounterVec
+	threadTweets       *prometheus.CounterVec
+	profileMentions    *prometheus.CounterVec
+	reconnects         *prometheus.CounterVec
+	connected          prometheus.Gauge
+	lastMessage        prometheus.Gauge
+	stallWarnings      prometheus.Counter
+	stallQueue         prometheus.Gauge
+	limitedTweets      prometheus.Counter
+	deletions          prometheus.Counter
+	messagesReceived   *prometheus.CounterVec
+	parseErrors        prometheus.Counter
+	tweetsProcessed    prometheus.Counter
+	parseDuration      prometheus.Histogram
+	messageBacklog     prometheus.GaugeFunc
+	paused             prometheus.GaugeFunc
+	handlerPanics      prometheus.Counter
+	labelOverflows     *prometheus.CounterVec
+	disconnects        *prometheus.CounterVec
+	deliveryLag        prometheus.Histogram
+	watchdogRestarts   prometheus.Counter
+	rateLimited        prometheus.Counter
+	rateLimitBackoff   prometheus.Gauge
+	receivedTweets     prometheus.Counter
+	sampleRate         prometheus.Gauge
+	hashtagPairs       *prometheus.CounterVec
+	trendingDesc       *prometheus.Desc
+	uniquesDesc        *prometheus.Desc
+	ratesDesc          *prometheus.Desc
+	anomalyDesc        *prometheus.Desc
+	firstSeenDesc      *prometheus.Desc
+	lastSeenDesc       *prometheus.Desc
+	linkDomains        *prometheus.CounterVec
+	media              *prometheus.CounterVec
+	verifiedTweets     *prometheus.CounterVec
+	clientApps         *prometheus.CounterVec
+	accountAge         prometheus.Histogram
+	tweetLength        prometheus.Histogram
+	tweetWords         prometheus.Histogram
+	sensitiveTweets    *prometheus.CounterVec
+	placeTweets        *prometheus.CounterVec
+	originalRetweets   prometheus.Histogram
+	originalFavorites  prometheus.Histogram
+	preciseTweets      prometheus.Counter
+	sentiment          *prometheus.CounterVec
+	sentimentScore     *prometheus.HistogramVec
+	emoji              *prometheus.CounterVec
+
+	classifiedTweets   *prometheus.CounterVec
+	classifierErrors   *prometheus.CounterVec
+	classifierDuration prometheus.Histogram
+}
 
-	matchingTweets *prometheus.CounterVec
-	tagMentions    *prometheus.CounterVec
-	userMentions   *prometheus.CounterVec
-	wordMentions   *prometheus.CounterVec
+// metricsConfig contains options applied to every exported metric.
+type metricsConfig struct {
+	namespace   string
+	constLabels map[string]string
+	// disableGoCollector and disableProcessCollector leave out the Go
+	// runtime and process metrics.
+	disableGoCollector      bool
+	disableProcessCollector bool
+	// expireAfter is how long a label combination may go without being
+	// updated before it's removed. Zero keeps them forever.
+	expireAfter time.Duration
+	// maxSeries is the number of label combinations each metric may have
+	// before further ones are labelled __overflow__. Zero is unlimited.
+	maxSeries int
 }
 
-// NewExporter returns an initialized Exporter.
-func NewExporter(c twitterConfig) (*Exporter, error) {
-	e := Exporter{}
+// NewExporter returns an initialized Exporter. Raw stream messages are
+// recorded to rec unless it's nil.
+func NewExporter(c twitterConfig, mc metricsConfig, rec *recorder) (*Exporter, error) {
+	e := Exporter{started: time.Now()}
 
 	e.matchingTweets = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "twitter_stream_tweets_total",
-		Help: "Total number of tweets delivered to the stream.",
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_tweets_total",
+		Help:        "Total number of tweets delivered to the stream.",
+	}, []string{"retweet", "quoted"})
+	e.excludedTweets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_excluded_tweets_total",
+		Help:        "Total number of tweets ignored because they contained an excluded term.",
 	}, []string{"retweet"})
+	e.languageTweets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_tweets_by_language_total",
+		Help:        "Total number of tweets delivered to the stream by language.",
+	}, []string{"lang", "retweet"})
+	e.followedTweets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_followed_user_tweets_total",
+		Help:        "Total number of tweets posted by followed users.",
+	}, []string{"user"})
+	e.geoTweets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_geo_tweets_total",
+		Help:        "Total number of tweets posted within each tracked location.",
+	}, []string{"box"})
+	e.replies = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_replies_total",
+		Help:        "Total number of tweets replying to tracked usernames.",
+	}, []string{"to_user"})
+	e.retweetedUsers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_tracked_user_retweeted_total",
+		Help:        "Total number of retweets of tweets posted by tracked usernames.",
+	}, []string{"keyword"})
 	e.tagMentions = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "twitter_stream_hashtag_mentions_total",
-		Help: "Total mentions of tracked keywords as hashtags.",
-	}, []string{"keyword", "retweet"})
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_hashtag_mentions_total",
+		Help:        "Total mentions of tracked keywords as hashtags.",
+	}, []string{"keyword", "group", "retweet", "quoted"})
 	e.userMentions = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "twitter_stream_user_mentions_total",
-		Help: "Total mentions of tracked keywords as usernames.",
-	}, []string{"keyword", "retweet"})
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_user_mentions_total",
+		Help:        "Total mentions of tracked keywords as usernames.",
+	}, []string{"keyword", "group", "retweet", "quoted"})
 	e.wordMentions = prometheus.NewCounterVec(prometheus.CounterOpts{
-		Name: "twitter_stream_word_mentions_total",
-		Help: "Total mentions of tracked keywords as raw words.",
-	}, []string{"keyword", "retweet"})
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_word_mentions_total",
+		Help:        "Total mentions of tracked keywords as raw words.",
+	}, []string{"keyword", "group", "retweet", "quoted"})
 
-	e.keywords = map[string]bool{}
-	for _, s := range c.track {
-		e.keywords[strings.ToLower(s)] = true
+	e.base = c
+	e.removed = map[string]bool{}
+	if c.startPaused {
+		e.isPaused = 1
+	}
+	e.cashMentions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_cashtag_mentions_total",
+		Help:        "Total mentions of tracked keywords as cashtags.",
+	}, []string{"keyword", "group", "retweet", "quoted"})
+	e.keywordMatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_keyword_matches_total",
+		Help:        "Total number of times a tracked keyword was matched, by where in the tweet it was found.",
+	}, []string{"keyword", "group", "match_type", "retweet", "quoted"})
+	e.matchedTweets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_matched_tweets_total",
+		Help:        "Total number of tweets matching each keyword, counting each tweet once per keyword.",
+	}, []string{"keyword", "retweet", "quoted"})
+	e.unmatchedTweets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_unmatched_tweets_total",
+		Help:        "Total number of tweets which didn't match any keyword locally.",
+	}, []string{"retweet"})
+	e.multiKeywordTweets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_multi_keyword_tweets_total",
+		Help:        "Total number of tweets matching keywords, by how many distinct keywords they matched.",
+	}, []string{"count"})
+	e.suspectedBots = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_suspected_bot_tweets_total",
+		Help:        "Total number of tweets matching each keyword whose authors look automated.",
+	}, []string{"keyword"})
+	e.profileMentions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_profile_mentions_total",
+		Help:        "Total number of tweets whose author's profile mentions each keyword, by profile field.",
+	}, []string{"keyword", "field"})
+	e.threadTweets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_thread_tweets_total",
+		Help:        "Total number of tweets matching each keyword which continue a thread by replying to their author's own tweet.",
+	}, []string{"keyword"})
+	e.duplicateTweets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_duplicate_tweets_total",
+		Help:        "Total number of tweets matching each keyword whose text nearly duplicated a recent tweet's.",
+	}, []string{"keyword"})
+	e.reconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_reconnects_total",
+		Help:        "Total number of times the stream has been reopened after closing unexpectedly.",
+	}, []string{"reason"})
+	e.connected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_connected",
+		Help:        "Whether the stream is currently connected to Twitter.",
+	})
+	e.lastMessage = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_last_message_timestamp_seconds",
+		Help:        "Unix time at which the last message was received from the stream.",
+	})
+	e.stallWarnings = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_stall_warnings_total",
+		Help:        "Total number of warnings from Twitter that the exporter is falling behind.",
+	})
+	e.stallQueue = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_stall_queue_full_percent",
+		Help:        "How full Twitter's queue of messages for the stream was at the last stall warning.",
+	})
+	e.limitedTweets = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_limited_tweets_total",
+		Help:        "Total number of matching tweets which Twitter didn't deliver because the stream exceeded its rate limit.",
+	})
+	e.deletions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_deletions_total",
+		Help:        "Total number of notices that a tweet delivered by the stream has been deleted.",
+	})
+	e.messagesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_exporter_messages_received_total",
+		Help:        "Total number of messages received from the stream, by type.",
+	}, []string{"type"})
+	e.parseErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_exporter_parse_errors_total",
+		Help:        "Total number of messages from the stream which couldn't be decoded.",
+	})
+	e.tweetsProcessed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_exporter_tweets_processed_total",
+		Help:        "Total number of tweets the exporter has finished processing, including those filtered out.",
+	})
+	e.parseDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_exporter_parse_duration_seconds",
+		Help:        "Time taken to process each tweet delivered to the stream.",
+		Buckets:     prometheus.ExponentialBuckets(0.00001, 4, 9),
+	})
+	e.messageBacklog = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_exporter_message_backlog",
+		Help:        "Number of messages read from the stream which are waiting to be processed.",
+	}, func() float64 {
+		e.mtx.RLock()
+		defer e.mtx.RUnlock()
+		return float64(len(e.backlog))
+	})
+	e.paused = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_paused",
+		Help:        "1 while the stream has been paused through the API, otherwise 0.",
+	}, func() float64 {
+		return float64(atomic.LoadInt32(&e.isPaused))
+	})
+	e.handlerPanics = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_exporter_handler_panics_total",
+		Help:        "Total number of messages from the stream whose processing panicked.",
+	})
+	e.labelOverflows = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_exporter_label_overflow_total",
+		Help:        "Total number of updates to a metric whose labels were set to __overflow__ as it already had -metrics.max-series label combinations.",
+	}, []string{"metric"})
+	e.series = newSeriesLimit(mc.maxSeries, mc.expireAfter, e.labelOverflows)
+	e.disconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_disconnects_total",
+		Help:        "Total number of disconnect messages sent by Twitter before closing the stream.",
+	}, []string{"code", "reason"})
+	e.deliveryLag = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_delivery_lag_seconds",
+		Help:        "Delay between tweets being posted and being processed by the exporter.",
+		Buckets:     []float64{0.5, 1, 2, 5, 10, 30, 60, 300, 900},
+	})
+	e.watchdogRestarts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_watchdog_restarts_total",
+		Help:        "Total number of times the stream was restarted because it stopped receiving data.",
+	})
+	e.rateLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_rate_limited_total",
+		Help:        "Total number of connection attempts rejected by Twitter with a 420 or 429 response.",
+	})
+	e.rateLimitBackoff = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_rate_limit_backoff_seconds",
+		Help:        "How long the exporter is waiting before reconnecting after being rate limited, or 0 if it isn't.",
+	})
+	e.receivedTweets = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_received_tweets_total",
+		Help:        "Total number of tweets received from the stream, including those skipped by -twitter.sample-rate.",
+	})
+	e.sampleRate = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_sample_rate",
+		Help:        "Fraction of received tweets which are counted by the other tweet metrics.",
+	})
+	e.hashtagPairs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_hashtag_pairs_total",
+		Help:        "Total number of tweets matching a keyword which also contained another hashtag.",
+	}, []string{"keyword", "other_hashtag"})
+	e.trendingDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(mc.namespace, "", "twitter_stream_trending_hashtags"),
+		"Estimated recent number of tweets containing each of the most frequent untracked hashtags.",
+		[]string{"hashtag"}, mc.constLabels,
+	)
+	e.linkDomains = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_link_domains_total",
+		Help:        "Total number of matching tweets which linked to each domain.",
+	}, []string{"domain", "retweet"})
+	e.media = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_media_total",
+		Help:        "Total number of photos, videos and animated GIFs attached to matching tweets.",
+	}, []string{"type", "retweet"})
+	e.verifiedTweets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_verified_tweets_total",
+		Help:        "Total number of matching tweets posted by verified accounts.",
+	}, []string{"retweet"})
+	e.clientApps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_client_apps_total",
+		Help:        "Total number of matching tweets posted with each client application.",
+	}, []string{"app"})
+	e.accountAge = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_author_account_age_days",
+		Help:        "Age of the accounts which posted matching tweets.",
+		Buckets:     []float64{1, 7, 30, 90, 365, 730, 1825, 3650},
+	})
+	e.tweetLength = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_tweet_length_chars",
+		Help:        "Length of the full text of matching tweets in characters.",
+		Buckets:     []float64{10, 20, 40, 80, 140, 200, 280, 500},
+	})
+	e.tweetWords = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_tweet_words",
+		Help:        "Number of words in the full text of matching tweets.",
+		Buckets:     []float64{1, 2, 5, 10, 20, 30, 50, 80},
+	})
+	e.sensitiveTweets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_possibly_sensitive_total",
+		Help:        "Total number of matching tweets whose links or media Twitter marked as possibly sensitive.",
+	}, []string{"retweet"})
+	e.placeTweets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_place_tweets_total",
+		Help:        "Total number of matching tweets tagged with a place, by the place's country and type.",
+	}, []string{"country_code", "place_type"})
+	e.preciseTweets = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_precise_location_tweets_total",
+		Help:        "Total number of matching tweets tagged with precise coordinates.",
+	})
+	e.sentiment = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_sentiment_total",
+		Help:        "Total number of tweets matching each keyword by their sentiment.",
+	}, []string{"keyword", "sentiment"})
+	e.sentimentScore = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_sentiment_score",
+		Help:        "Sentiment of the tweets matching each keyword, from -1 (negative) to 1 (positive).",
+		Buckets:     []float64{-0.75, -0.5, -0.25, -0.05, 0.05, 0.25, 0.5, 0.75, 1},
+	}, []string{"keyword"})
+	e.emoji = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_emoji_total",
+		Help:        "Total number of matching tweets containing each emoji.",
+	}, []string{"emoji", "retweet"})
+	e.classifiedTweets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_classified_tweets_total",
+		Help:        "Total number of tweets matching each keyword by the label given by -classifier.url.",
+	}, []string{"keyword", "label"})
+	e.classifierErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_classifier_errors_total",
+		Help:        "Total number of matching tweets which couldn't be classified, by reason.",
+	}, []string{"reason"})
+	e.classifierDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_classifier_request_duration_seconds",
+		Help:        "Time taken by requests to -classifier.url.",
+		Buckets:     []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	})
+	e.uniquesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(mc.namespace, "", "twitter_stream_unique_authors"),
+		"Estimated number of distinct users who posted tweets matching each keyword within the window.",
+		[]string{"keyword", "window"}, mc.constLabels,
+	)
+	e.originalRetweets = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_original_retweet_count",
+		Help:        "Number of times the originals of matching retweets had been retweeted when they were retweeted.",
+		Buckets:     prometheus.ExponentialBuckets(1, 10, 7),
+	})
+	e.originalFavorites = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   mc.namespace,
+		ConstLabels: mc.constLabels,
+		Name:        "twitter_stream_original_favorite_count",
+		Help:        "Number of times the originals of matching retweets had been liked when they were retweeted.",
+		Buckets:     prometheus.ExponentialBuckets(1, 10, 7),
+	})
+	e.ratesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(mc.namespace, "", "twitter_stream_tweets_rate_5m"),
+		"Tweets per second matching each keyword over the last 5 minutes.",
+		[]string{"keyword"}, mc.constLabels,
+	)
+	e.anomalyDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(mc.namespace, "", "twitter_stream_keyword_anomaly_score"),
+		"Number of standard deviations the last minute's count of tweets matching each keyword was from its recent average.",
+		[]string{"keyword"}, mc.constLabels,
+	)
+	e.firstSeenDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(mc.namespace, "", "twitter_stream_keyword_first_seen_timestamp_seconds"),
+		"Unix time at which a tweet matching each keyword was first seen.",
+		[]string{"keyword"}, mc.constLabels,
+	)
+	e.lastSeenDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(mc.namespace, "", "twitter_stream_keyword_last_seen_timestamp_seconds"),
+		"Unix time at which a tweet matching each keyword was last seen.",
+		[]string{"keyword"}, mc.constLabels,
+	)
+	e.rates = newKeywordRates()
+	e.backoffs = newReconnectBackOffs()
+	e.tail = newTailBroker()
+	e.rec = rec
+	e.cursors = newSearchCursors()
+	if mc.expireAfter > 0 {
+		x := newLabelExpirer(mc.expireAfter, mc.constLabels,
+			e.matchingTweets.MetricVec, e.excludedTweets.MetricVec, e.languageTweets.MetricVec,
+			e.followedTweets.MetricVec, e.geoTweets.MetricVec, e.replies.MetricVec,
+			e.retweetedUsers.MetricVec, e.matchedTweets.MetricVec, e.unmatchedTweets.MetricVec,
+			e.multiKeywordTweets.MetricVec, e.tagMentions.MetricVec, e.userMentions.MetricVec,
+			e.wordMentions.MetricVec, e.cashMentions.MetricVec, e.keywordMatches.MetricVec,
+			e.suspectedBots.MetricVec, e.duplicateTweets.MetricVec, e.threadTweets.MetricVec,
+			e.profileMentions.MetricVec, e.reconnects.MetricVec, e.messagesReceived.MetricVec,
+			e.disconnects.MetricVec, e.hashtagPairs.MetricVec, e.linkDomains.MetricVec,
+			e.media.MetricVec, e.verifiedTweets.MetricVec, e.clientApps.MetricVec,
+			e.sensitiveTweets.MetricVec, e.placeTweets.MetricVec, e.emoji.MetricVec,
+			e.sentiment.MetricVec, e.sentimentScore.MetricVec, e.classifiedTweets.MetricVec,
+			e.classifierErrors.MetricVec,
+		)
+		go x.run()
 	}
 
-	fp := &twitter.StreamFilterParams{
-		Track:         c.track,
-		StallWarnings: twitter.Bool(true),
+	if c.listID != "" {
+		members, err := getListMembers(c)
+		if err != nil {
+			return nil, err
+		}
+		e.listMembers = members
+	}
+	if err := e.restart(); err != nil {
+		e.Stop()
+		return nil, err
 	}
 
-	s, err := getTwitterClient(c).Streams.Filter(fp)
+	return &e, nil
+}
+
+// connect opens a stream tracking the keywords in c and starts processing
+// the messages it delivers.
+func (e *Exporter) connect(c twitterConfig) error {
+	kw := buildKeywords(c)
+	m := newMatcher(kw, c.foldDiacritics)
+
+	ex := map[string]bool{}
+	for _, s := range c.exclude {
+		ex[m.fold(strings.ToLower(s))] = true
+	}
+	// Followed users are labelled with their IDs unless they're list
+	// members, whose screen names are known.
+	follow := map[string]string{}
+	for _, id := range c.follow {
+		follow[id] = id
+		if name, ok := c.followNames[id]; ok {
+			follow[id] = name
+		}
+	}
+
+	langs := map[string]bool{}
+	for _, l := range c.languages {
+		langs[strings.ToLower(l)] = true
+	}
+
+	s, err := newTweetSource(c.source)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if err := s.Connect(c, e); err != nil {
+		return err
 	}
 
+	e.mtx.Lock()
+	e.keywords = kw
+	e.matcher = m
+	e.exclude = ex
+	e.follow = follow
+	e.boxes = c.locations
+	e.langs = langs
+	e.quoted = c.countQuoted
+	e.logMatches = c.logSampleMatches
+	e.scored = c.sentiment
+	e.profiled = c.profileMentions
+	labels := map[string]bool{}
+	for _, k := range kw {
+		labels[k.label] = true
+	}
+	e.rates.setKeywords(labels)
+	e.bots = newBotDetector(c)
+	if !c.duplicates {
+		e.dupes = nil
+	} else if e.dupes == nil || e.dupes.window != c.duplicatesWindow || e.dupes.exclude != c.duplicatesExclude {
+		e.dupes = newDuplicateFilter(c.duplicatesWindow, c.duplicatesExclude)
+	}
+	if !c.uniqueAuthors {
+		e.uniques = nil
+	} else {
+		if e.uniques == nil {
+			e.uniques = newAuthorCounter()
+		}
+		e.uniques.prune(labels)
+	}
+	if max, allow := emojiLimit(c); !c.emoji {
+		e.emojis = nil
+	} else if e.emojis == nil || !e.emojis.sameLimits(max, allow, nil) {
+		e.emojis = newLabelLimit(max, allow, nil)
+	}
+	if c.classifierURL == "" {
+		e.cl = nil
+	} else if e.cl == nil || !e.cl.sameConfig(c) {
+		e.cl = newClassifier(c)
+	}
+	e.rate = c.sampleRate
+	e.replaying = c.source == "file"
+	if max, allow := hashtagPairLimit(c); !c.hashtagPairs {
+		e.pairs = nil
+	} else if e.pairs == nil || !e.pairs.sameLimits(max, allow, nil) {
+		e.pairs = newLabelLimit(max, allow, nil)
+	}
+	if c.trendingHashtags == 0 {
+		e.trending = nil
+	} else if e.trending == nil || e.trending.k != c.trendingHashtags || e.trending.halfLife != c.trendingHalfLife {
+		e.trending = newTopK(c.trendingHashtags, c.trendingHalfLife)
+	}
+	if !c.linkDomains {
+		e.domains = nil
+	} else if e.domains == nil || !e.domains.sameLimits(c.linkDomainsMax, c.linkDomainsAllow, c.linkDomainsDeny) {
+		e.domains = newLabelLimit(c.linkDomainsMax, c.linkDomainsAllow, c.linkDomainsDeny)
+	}
+	if !c.clientApps {
+		e.apps = nil
+	} else if e.apps == nil || !e.apps.sameLimits(c.clientAppsMax, c.clientAppsAllow, nil) {
+		e.apps = newLabelLimit(c.clientAppsMax, c.clientAppsAllow, nil)
+	}
+	msgs := make(chan interface{}, messageBuffer)
+	e.backlog = msgs
+	e.mtx.Unlock()
+	e.sampleRate.Set(c.sampleRate)
 	e.stream = s
+	e.stallQueue.Set(0)
 
+	var received bool
+	var lastErr error
 	d := twitter.NewSwitchDemux()
-	d.Tweet = e.parseTweet
-	go d.HandleChan(e.stream.Messages)
+	d.All = func(msg interface{}) {
+		e.messagesReceived.WithLabelValues(e.series.values("twitter_stream_exporter_messages_received_total", messageType(msg))...).Inc()
+		if _, ok := msg.(error); !ok {
+			received = true
+			e.lastMessage.Set(float64(time.Now().UnixNano()) / 1e9)
+		}
+	}
+	d.Tweet = func(t *twitter.Tweet) {
+		start := time.Now()
+		atomic.StoreInt64(&e.lastTweet, start.UnixNano())
+		e.parseTweet(t)
+		e.parseDuration.Observe(time.Since(start).Seconds())
+	}
+	d.Warning = e.stallWarning
+	d.StreamDisconnect = e.disconnect
+	d.StatusDeletion = func(*twitter.StatusDeletion) {
+		e.deletions.Inc()
+	}
+	// Limit notices give the number of undelivered tweets since the
+	// connection was opened, so only the increase is counted.
+	var limited int64
+	d.StreamLimit = func(l *twitter.StreamLimit) {
+		if l.Track < limited {
+			limited = 0
+		}
+		e.limitedTweets.Add(float64(l.Track - limited))
+		limited = l.Track
+	}
+	d.Other = func(msg interface{}) {
+		if err, ok := msg.(error); ok {
+			if isParseError(err) {
+				e.parseError()
+				return
+			}
+			lastErr = err
+		}
+	}
+	// Messages are relayed through a buffer so that bursts can be read from
+	// the connection while earlier tweets are still being processed.
+	go func() {
+		for msg := range s.Messages() {
+			msgs <- msg
+		}
+		close(msgs)
+	}()
+	e.handlers.Add(1)
+	go func() {
+		defer e.handlers.Done()
+		for msg := range msgs {
+			e.handleMessage(d, msg)
+		}
+		e.streamClosed(s, received, lastErr)
+	}()
 
-	return &e, nil
+	return nil
+}
+
+// handleMessage passes msg to d, recovering from any panic so that a single
+// malformed message can't stop the stream from being processed.
+func (e *Exporter) handleMessage(d twitter.SwitchDemux, msg interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			e.handlerPanics.Inc()
+			payload, err := json.Marshal(msg)
+			if err != nil {
+				payload = []byte(fmt.Sprintf("%#v", msg))
+			}
+			logError("Recovered from panic handling message", "panic", r, "stack", string(debug.Stack()), "message", string(payload))
+		}
+	}()
+	d.Handle(msg)
+}
+
+// setConnected implements streamObserver, updating the connection state.
+func (e *Exporter) setConnected(connected bool) {
+	if connected {
+		e.streamActivity()
+		atomic.StoreInt32(&e.isConnected, 1)
+		e.connected.Set(1)
+	} else {
+		atomic.StoreInt32(&e.isConnected, 0)
+		e.connected.Set(0)
+	}
+}
+
+// messageRecorder implements streamObserver.
+func (e *Exporter) messageRecorder() *recorder {
+	return e.rec
+}
+
+// searchCursors implements streamObserver.
+func (e *Exporter) searchCursors() *searchCursors {
+	return e.cursors
+}
+
+// parseError implements streamObserver, counting a message which couldn't
+// be decoded.
+func (e *Exporter) parseError() {
+	e.parseErrors.Inc()
+}
+
+// streamActivity implements streamObserver, recording that data has been
+// received from the stream.
+func (e *Exporter) streamActivity() {
+	atomic.StoreInt64(&e.lastActivity, time.Now().UnixNano())
+}
+
+// Reload replaces the stream with one using the keywords and credentials in
+// c. Twitter only permits one stream per account, so the existing stream is
+// closed before the new one is opened. If the new stream can't be opened
+// the error is returned and, as c has already been applied, the stream is
+// retried with it after a backoff.
+// Keywords added or removed through the API are kept.
+func (e *Exporter) Reload(c twitterConfig) error {
+	e.streamMtx.Lock()
+	defer e.streamMtx.Unlock()
+	if c.listID != e.base.listID {
+		var members map[string]string
+		if c.listID != "" {
+			var err error
+			if members, err = getListMembers(c); err != nil {
+				return err
+			}
+		}
+		e.listMembers = members
+	}
+	e.base = c
+	return e.restart()
+}
+
+// UpdateKeywords starts tracking the keywords in add and stops tracking those
+// in remove, restarting the stream. If the new stream can't be opened the
+// previous keywords are restored, and if the stream can't be reopened with
+// those either it's retried after a backoff rather than left closed.
+func (e *Exporter) UpdateKeywords(add, remove []string) error {
+	e.streamMtx.Lock()
+	defer e.streamMtx.Unlock()
+
+	added := []string{}
+	removed := map[string]bool{}
+	for k := range e.removed {
+		removed[k] = true
+	}
+	for _, t := range remove {
+		lt := strings.ToLower(t)
+		if !containsFold(e.base.track, t) && !containsFold(e.added, t) || removed[lt] {
+			return fmt.Errorf("Keyword %q is not being tracked", t)
+		}
+		removed[lt] = true
+	}
+	for _, t := range e.added {
+		if !removed[strings.ToLower(t)] {
+			added = append(added, t)
+		}
+	}
+	for _, t := range add {
+		delete(removed, strings.ToLower(t))
+		if !containsFold(added, t) {
+			added = append(added, t)
+		}
+	}
+
+	c := applyKeywordChanges(e.base, added, removed)
+	if errs := c.validate(); len(errs) > 0 {
+		return errs[0]
+	}
+
+	prevAdded, prevRemoved := e.added, e.removed
+	e.added, e.removed = added, removed
+	if err := e.restart(); err != nil {
+		e.added, e.removed = prevAdded, prevRemoved
+		if rerr := e.restart(); rerr != nil {
+			logError("Error restoring previous keywords, the stream will be retried", "err", rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// restart reconnects with the base configuration and API changes applied.
+// While the stream is paused only the tracked keywords are updated, and the
+// rest of the configuration is applied when it resumes. If the new stream
+// can't be opened it's retried after a backoff, as when reconnecting, so
+// that a failed restart doesn't leave the exporter without a stream.
+// streamMtx must be held.
+func (e *Exporter) restart() error {
+	if e.retryTimer != nil {
+		e.retryTimer.Stop()
+	}
+	if e.stream != nil {
+		e.stream.Stop()
+		e.stream = nil
+	}
+	c := withListMembers(applyKeywordChanges(e.base, e.added, e.removed), e.listMembers)
+	if atomic.LoadInt32(&e.isPaused) == 1 {
+		kw := buildKeywords(c)
+		e.mtx.Lock()
+		e.keywords = kw
+		e.matcher = newMatcher(kw, c.foldDiacritics)
+		e.mtx.Unlock()
+		return nil
+	}
+	if err := e.connect(c); err != nil {
+		wait := e.backoffs.next("")
+		logWarn("Retrying stream after backoff", "wait", wait)
+		e.retryTimer = time.AfterFunc(wait, e.reconnect)
+		return err
+	}
+	return nil
+}
+
+// applyKeywordChanges returns c with the keywords in added tracked and those
+// in removed, which are lowercase, no longer tracked.
+func applyKeywordChanges(c twitterConfig, added []string, removed map[string]bool) twitterConfig {
+	track := c.track
+	c.track = nil
+	for _, t := range track {
+		if !removed[strings.ToLower(t)] {
+			c.track = append(c.track, t)
+		}
+	}
+	for _, t := range added {
+		if !containsFold(c.track, t) {
+			c.track = append(c.track, t)
+		}
+	}
+	return c
+}
+
+// Keywords returns the set of keywords currently being tracked.
+func (e *Exporter) Keywords() map[string]keyword {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return e.keywords
+}
+
+// Drain waits until the messages already read from stopped streams have
+// been processed, or ctx is done.
+func (e *Exporter) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		e.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop closes the stream and prevents it from being reopened.
+func (e *Exporter) Stop() {
+	e.streamMtx.Lock()
+	defer e.streamMtx.Unlock()
+	e.stopped = true
+	if e.retryTimer != nil {
+		e.retryTimer.Stop()
+	}
+	if e.stream != nil {
+		e.stream.Stop()
+		e.stream = nil
+	}
 }
 
 // Collect implements the Prometheus collector interface.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	e.matchingTweets.Collect(ch)
+	e.excludedTweets.Collect(ch)
+	e.languageTweets.Collect(ch)
+	e.followedTweets.Collect(ch)
+	e.geoTweets.Collect(ch)
+	e.replies.Collect(ch)
+	e.retweetedUsers.Collect(ch)
 	e.tagMentions.Collect(ch)
 	e.userMentions.Collect(ch)
 	e.wordMentions.Collect(ch)
+	e.reconnects.Collect(ch)
+	e.connected.Collect(ch)
+	e.lastMessage.Collect(ch)
+	e.stallWarnings.Collect(ch)
+	e.stallQueue.Collect(ch)
+	e.limitedTweets.Collect(ch)
+	e.deletions.Collect(ch)
+	e.messagesReceived.Collect(ch)
+	e.parseErrors.Collect(ch)
+	e.tweetsProcessed.Collect(ch)
+	e.parseDuration.Collect(ch)
+	e.messageBacklog.Collect(ch)
+	e.paused.Collect(ch)
+	e.handlerPanics.Collect(ch)
+	e.labelOverflows.Collect(ch)
+	e.disconnects.Collect(ch)
+	e.deliveryLag.Collect(ch)
+	e.watchdogRestarts.Collect(ch)
+	e.rateLimited.Collect(ch)
+	e.rateLimitBackoff.Collect(ch)
+	e.cashMentions.Collect(ch)
+	e.keywordMatches.Collect(ch)
+	e.matchedTweets.Collect(ch)
+	e.unmatchedTweets.Collect(ch)
+	e.multiKeywordTweets.Collect(ch)
+	e.suspectedBots.Collect(ch)
+	e.duplicateTweets.Collect(ch)
+	e.threadTweets.Collect(ch)
+	e.profileMentions.Collect(ch)
+	e.receivedTweets.Collect(ch)
+	e.sampleRate.Collect(ch)
+	e.hashtagPairs.Collect(ch)
+	e.linkDomains.Collect(ch)
+	e.media.Collect(ch)
+	e.verifiedTweets.Collect(ch)
+	e.clientApps.Collect(ch)
+	e.accountAge.Collect(ch)
+	e.tweetLength.Collect(ch)
+	e.tweetWords.Collect(ch)
+	e.sensitiveTweets.Collect(ch)
+	e.placeTweets.Collect(ch)
+	e.originalRetweets.Collect(ch)
+	e.originalFavorites.Collect(ch)
+	e.preciseTweets.Collect(ch)
+	e.sentiment.Collect(ch)
+	e.sentimentScore.Collect(ch)
+	e.emoji.Collect(ch)
+	e.classifiedTweets.Collect(ch)
+	e.classifierErrors.Collect(ch)
+	e.classifierDuration.Collect(ch)
+
+	e.mtx.RLock()
+	trending, uniques := e.trending, e.uniques
+	e.mtx.RUnlock()
+	if trending != nil {
+		for _, t := range trending.top() {
+			ch <- prometheus.MustNewConstMetric(e.trendingDesc, prometheus.GaugeValue, t.count, t.tag)
+		}
+	}
+	if uniques != nil {
+		uniques.each(func(keyword, window string, count float64) {
+			ch <- prometheus.MustNewConstMetric(e.uniquesDesc, prometheus.GaugeValue, count, keyword, window)
+		})
+	}
+	e.rates.each(func(keyword string, rate, score float64) {
+		ch <- prometheus.MustNewConstMetric(e.ratesDesc, prometheus.GaugeValue, rate, keyword)
+		ch <- prometheus.MustNewConstMetric(e.anomalyDesc, prometheus.GaugeValue, score, keyword)
+	})
+	e.rates.seen(func(keyword string, first, last time.Time) {
+		ch <- prometheus.MustNewConstMetric(e.firstSeenDesc, prometheus.GaugeValue, float64(first.UnixNano())/1e9, keyword)
+		ch <- prometheus.MustNewConstMetric(e.lastSeenDesc, prometheus.GaugeValue, float64(last.UnixNano())/1e9, keyword)
+	})
 }
 
 // Describe implements the Prometheus collector interface.
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	e.matchingTweets.Describe(ch)
+	e.excludedTweets.Describe(ch)
+	e.languageTweets.Describe(ch)
+	e.followedTweets.Describe(ch)
+	e.geoTweets.Describe(ch)
+	e.replies.Describe(ch)
+	e.retweetedUsers.Describe(ch)
 	e.tagMentions.Describe(ch)
 	e.userMentions.Describe(ch)
 	e.wordMentions.Describe(ch)
+	e.reconnects.Describe(ch)
+	e.connected.Describe(ch)
+	e.lastMessage.Describe(ch)
+	e.stallWarnings.Describe(ch)
+	e.stallQueue.Describe(ch)
+	e.limitedTweets.Describe(ch)
+	e.deletions.Describe(ch)
+	e.messagesReceived.Describe(ch)
+	e.parseErrors.Describe(ch)
+	e.tweetsProcessed.Describe(ch)
+	e.parseDuration.Describe(ch)
+	e.messageBacklog.Describe(ch)
+	e.paused.Describe(ch)
+	e.handlerPanics.Describe(ch)
+	e.labelOverflows.Describe(ch)
+	e.disconnects.Describe(ch)
+	e.deliveryLag.Describe(ch)
+	e.watchdogRestarts.Describe(ch)
+	e.rateLimited.Describe(ch)
+	e.rateLimitBackoff.Describe(ch)
+	e.cashMentions.Describe(ch)
+	e.keywordMatches.Describe(ch)
+	e.matchedTweets.Describe(ch)
+	e.unmatchedTweets.Describe(ch)
+	e.multiKeywordTweets.Describe(ch)
+	e.suspectedBots.Describe(ch)
+	e.duplicateTweets.Describe(ch)
+	e.threadTweets.Describe(ch)
+	e.profileMentions.Describe(ch)
+	e.receivedTweets.Describe(ch)
+	e.sampleRate.Describe(ch)
+	e.hashtagPairs.Describe(ch)
+	e.linkDomains.Describe(ch)
+	e.media.Describe(ch)
+	e.verifiedTweets.Describe(ch)
+	e.clientApps.Describe(ch)
+	e.accountAge.Describe(ch)
+	e.tweetLength.Describe(ch)
+	e.tweetWords.Describe(ch)
+	e.sensitiveTweets.Describe(ch)
+	e.placeTweets.Describe(ch)
+	e.originalRetweets.Describe(ch)
+	e.originalFavorites.Describe(ch)
+	e.preciseTweets.Describe(ch)
+	e.sentiment.Describe(ch)
+	e.sentimentScore.Describe(ch)
+	e.emoji.Describe(ch)
+	e.classifiedTweets.Describe(ch)
+	e.classifierErrors.Describe(ch)
+	e.classifierDuration.Describe(ch)
+	ch <- e.trendingDesc
+	ch <- e.uniquesDesc
+	ch <- e.ratesDesc
+	ch <- e.anomalyDesc
+	ch <- e.firstSeenDesc
+	ch <- e.lastSeenDesc
 }
 
 // parseTweet reads a single tweet and increments the appropriate counters.
 func (e *Exporter) parseTweet(t *twitter.Tweet) {
+	defer e.tweetsProcessed.Inc()
+	e.mtx.RLock()
+	rate, replaying := e.rate, e.replaying
+	e.mtx.RUnlock()
+	if created, err := time.Parse(time.RubyDate, t.CreatedAt); err == nil && !replaying {
+		lag := time.Since(created).Seconds()
+		if lag < 0 {
+			lag = 0
+		}
+		e.deliveryLag.Observe(lag)
+	}
+
+	e.receivedTweets.Inc()
+	if rate < 1 && !sampled(t.IDStr, rate) {
+		return
+	}
+
 	var rt string
 	var s *twitter.Tweet
 	if t.RetweetedStatus != nil {
@@ -132,85 +1467,609 @@ func (e *Exporter) parseTweet(t *twitter.Tweet) {
 		rt = "false"
 		s = t
 	}
+	// Quote tweets add commentary, so they're labelled separately from
+	// pure amplification. A retweet of a quote tweet is both.
+	quote := "false"
+	if s.QuotedStatus != nil || s.QuotedStatusIDStr != "" {
+		quote = "true"
+	}
 
-	e.matchingTweets.WithLabelValues(rt).Inc()
+	e.mtx.RLock()
+	m, exclude, follow, boxes, langs, quoted, pairs, trending, domains := e.matcher, e.exclude, e.follow, e.boxes, e.langs, e.quoted, e.pairs, e.trending, e.domains
+	apps, scored, cl, emojis, uniques, bots, dupes := e.apps, e.scored, e.cl, e.emojis, e.uniques, e.bots, e.dupes
+	profiled, logMatches := e.profiled, e.logMatches
+	e.mtx.RUnlock()
+
+	// Twitter should only deliver tweets in the requested languages, but
+	// check in case its detection differs.
+	if len(langs) > 0 && !langs[strings.ToLower(t.Lang)] {
+		return
+	}
+
+	if isExcluded(s, m, exclude) {
+		e.excludedTweets.WithLabelValues(e.series.values("twitter_stream_excluded_tweets_total", rt)...).Inc()
+		return
+	}
+
+	e.matchingTweets.WithLabelValues(e.series.values("twitter_stream_tweets_total", rt, quote)...).Inc()
+	lang := t.Lang
+	if lang == "" {
+		lang = "und"
+	}
+	e.languageTweets.WithLabelValues(e.series.values("twitter_stream_tweets_by_language_total", lang, rt)...).Inc()
+	e.countMedia(s, rt)
+	if t.RetweetedStatus != nil {
+		e.originalRetweets.Observe(float64(s.RetweetCount))
+		e.originalFavorites.Observe(float64(s.FavoriteCount))
+	}
+	if s.PossiblySensitive {
+		e.sensitiveTweets.WithLabelValues(e.series.values("twitter_stream_possibly_sensitive_total", rt)...).Inc()
+	}
+	e.tweetLength.Observe(float64(utf8.RuneCountInString(s.Text)))
+	e.tweetWords.Observe(float64(len(strings.Fields(s.Text))))
+	if apps != nil {
+		e.countClientApp(apps, t)
+	}
+	if t.User != nil {
+		if name, ok := follow[t.User.IDStr]; ok {
+			e.followedTweets.WithLabelValues(e.series.values("twitter_stream_followed_user_tweets_total", name)...).Inc()
+		}
+		if t.User.Verified {
+			e.verifiedTweets.WithLabelValues(e.series.values("twitter_stream_verified_tweets_total", rt)...).Inc()
+		}
+		if created, err := time.Parse(time.RubyDate, t.User.CreatedAt); err == nil {
+			e.accountAge.Observe(time.Since(created).Hours() / 24)
+		}
+	}
+	for _, b := range boxes {
+		if b.contains(t) {
+			e.geoTweets.WithLabelValues(e.series.values("twitter_stream_geo_tweets_total", b.name)...).Inc()
+		}
+	}
+	if t.Place != nil {
+		e.placeTweets.WithLabelValues(e.series.values("twitter_stream_place_tweets_total", orUnknown(t.Place.CountryCode), orUnknown(t.Place.PlaceType))...).Inc()
+	}
+	if t.Coordinates != nil {
+		e.preciseTweets.Inc()
+	}
+	if s.InReplyToScreenName != "" {
+		m.token(s.InReplyToScreenName, func(kw keyword) {
+			e.replies.WithLabelValues(e.series.values("twitter_stream_replies_total", kw.label)...).Inc()
+		})
+	}
+	if t.RetweetedStatus != nil && s.User != nil {
+		m.token(s.User.ScreenName, func(kw keyword) {
+			e.retweetedUsers.WithLabelValues(e.series.values("twitter_stream_tracked_user_retweeted_total", kw.label)...).Inc()
+		})
+	}
 
-	for _, h := range s.Entities.Hashtags {
-		lh := strings.ToLower(h.Text)
-		if e.keywords[lh] {
-			e.tagMentions.WithLabelValues(lh, rt).Inc()
+	// Retweets are duplicates by design, so only original tweets are
+	// checked.
+	dup := dupes != nil && t.RetweetedStatus == nil && dupes.duplicate(s.Text)
+	var matched map[string]bool
+	if dup && dupes.exclude {
+		matched = findMentions(m, s, func(keyword, string) {})
+	} else {
+		matched = e.countMentions(m, s, rt, "false")
+	}
+	if dup {
+		for kw := range matched {
+			e.duplicateTweets.WithLabelValues(e.series.values("twitter_stream_duplicate_tweets_total", kw)...).Inc()
+		}
+	}
+	if pairs != nil {
+		e.countHashtagPairs(pairs, m, s, matched)
+	}
+	if scored && len(matched) > 0 {
+		score := sentimentScore(s.Text)
+		label := sentimentLabel(score)
+		for kw := range matched {
+			e.sentiment.WithLabelValues(e.series.values("twitter_stream_sentiment_total", kw, label)...).Inc()
+			e.sentimentScore.WithLabelValues(e.series.values("twitter_stream_sentiment_score", kw)...).Observe(score)
+		}
+	}
+	if emojis != nil {
+		e.countEmoji(emojis, s, rt)
+	}
+	if profiled {
+		e.countProfileMentions(m, t)
+	}
+	if cl != nil && len(matched) > 0 {
+		e.classifyTweet(cl, s, matched)
+	}
+	if trending != nil && s.Entities != nil {
+		for _, h := range s.Entities.Hashtags {
+			if !isTrackedHashtag(m, h.Text) {
+				trending.add(h.Text)
+			}
 		}
 	}
-	for _, u := range s.Entities.UserMentions {
-		lu := strings.ToLower(u.ScreenName)
-		if e.keywords[lu] {
-			e.userMentions.WithLabelValues(lu, rt).Inc()
+	if domains != nil {
+		e.countLinkDomains(domains, s, rt)
+	}
+	if quoted && s.QuotedStatus != nil {
+		for kw := range e.countMentions(m, s.QuotedStatus, rt, "true") {
+			matched[kw] = true
 		}
 	}
-	for _, w := range strings.Fields(strings.ToLower(s.Text)) {
-		if e.keywords[w] {
-			e.wordMentions.WithLabelValues(w, rt).Inc()
+	if logMatches > 0 && len(matched) > 0 && atomic.AddUint64(&e.matchCount, 1)%uint64(logMatches) == 0 {
+		logMatch(m, t, s, quoted)
+	}
+	if e.tail.active() {
+		e.tail.publish(newTailEvent(t, matched))
+	}
+	if len(matched) == 0 {
+		e.unmatchedTweets.WithLabelValues(e.series.values("twitter_stream_unmatched_tweets_total", rt)...).Inc()
+	} else {
+		e.multiKeywordTweets.WithLabelValues(e.series.values("twitter_stream_multi_keyword_tweets_total", keywordCountLabel(len(matched)))...).Inc()
+	}
+	bot := bots != nil && len(matched) > 0 && bots.suspected(t)
+	thread := t.User != nil && t.InReplyToUserIDStr != "" && t.InReplyToUserIDStr == t.User.IDStr
+	for kw := range matched {
+		e.matchedTweets.WithLabelValues(e.series.values("twitter_stream_matched_tweets_total", kw, rt, quote)...).Inc()
+		if bot {
+			e.suspectedBots.WithLabelValues(e.series.values("twitter_stream_suspected_bot_tweets_total", kw)...).Inc()
+		}
+		if thread {
+			e.threadTweets.WithLabelValues(e.series.values("twitter_stream_thread_tweets_total", kw)...).Inc()
+		}
+		e.rates.add(kw)
+		if uniques != nil && t.User != nil {
+			uniques.add(kw, t.User.IDStr)
 		}
 	}
 }
 
-func main() {
-	var (
-		track         = flag.String("twitter.track", "", "Mandatory comma-separated list of keywords to track.")
-		listenAddress = flag.String("web.listen-address", ":19000", "Address to listen on for web interface and telemetry.")
-		metricsPath   = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
-	)
-	flag.Parse()
-	if *track == "" {
-		log.Fatalf("At least one keyword must be provided to -twitter.track")
+// messageBuffer is the number of messages which are read from a stream ahead
+// of those being processed.
+const messageBuffer = 1000
+
+// logMatch logs the ID and author of t and the keywords it matched, with how
+// they matched, so that what's being counted can be checked.
+func logMatch(m *matcher, t, s *twitter.Tweet, quoted bool) {
+	found := map[string]bool{}
+	findMentions(m, s, func(kw keyword, matchType string) {
+		found[kw.label+":"+matchType] = true
+	})
+	if quoted && s.QuotedStatus != nil {
+		findMentions(m, s.QuotedStatus, func(kw keyword, matchType string) {
+			found[kw.label+":quoted_"+matchType] = true
+		})
+	}
+	matches := make([]string, 0, len(found))
+	for match := range found {
+		matches = append(matches, match)
+	}
+	sort.Strings(matches)
+	var author string
+	if t.User != nil {
+		author = t.User.ScreenName
 	}
+	logInfo("Sampled match", "id", t.IDStr, "author", author, "matches", strings.Join(matches, ","))
+}
+
+// maxKeywordCount is the number of matched keywords from which tweets are
+// counted together by twitter_stream_multi_keyword_tweets_total.
+const maxKeywordCount = 5
 
-	c := twitterConfig{
-		accessToken:    os.Getenv(envAccessToken),
-		tokenSecret:    os.Getenv(envAccessSecret),
-		consumerKey:    os.Getenv(envConsumerKey),
-		consumerSecret: os.Getenv(envConsumerSecret),
-		track:          strings.Split(*track, ","),
+// keywordCountLabel returns the count label for a tweet matching n keywords.
+func keywordCountLabel(n int) string {
+	if n >= maxKeywordCount {
+		return strconv.Itoa(maxKeywordCount) + "+"
 	}
-	if c.accessToken == "" {
-		log.Fatalf("No Twitter access token provided, please set %s", envAccessToken)
+	return strconv.Itoa(n)
+}
+
+// countMentions increments the mention counters for each keyword in the
+// entities and text of s.
+func (e *Exporter) countMentions(m *matcher, s *twitter.Tweet, rt, quoted string) map[string]bool {
+	debug := logEnabled(levelDebug)
+	return findMentions(m, s, func(kw keyword, matchType string) {
+		if debug {
+			logDebug("Matched keyword", "id", s.IDStr, "keyword", kw.label, "group", kw.group, "match_type", matchType, "retweet", rt, "quoted", quoted)
+		}
+		var vec *prometheus.CounterVec
+		var name string
+		switch matchType {
+		case "hashtag":
+			vec, name = e.tagMentions, "twitter_stream_hashtag_mentions_total"
+		case "mention":
+			vec, name = e.userMentions, "twitter_stream_user_mentions_total"
+		case "cashtag":
+			vec, name = e.cashMentions, "twitter_stream_cashtag_mentions_total"
+		case "word":
+			vec, name = e.wordMentions, "twitter_stream_word_mentions_total"
+		}
+		if vec != nil {
+			vec.WithLabelValues(e.series.values(name, kw.label, kw.group, rt, quoted)...).Inc()
+		}
+		e.keywordMatches.WithLabelValues(e.series.values("twitter_stream_keyword_matches_total", kw.label, kw.group, matchType, rt, quoted)...).Inc()
+	})
+}
+
+// findMentions calls fn for each keyword in the entities and text of s with
+// the type of match, and returns the labels of the keywords which matched.
+func findMentions(m *matcher, s *twitter.Tweet, fn func(kw keyword, matchType string)) map[string]bool {
+	matched := map[string]bool{}
+	found := func(matchType string) func(keyword) {
+		return func(kw keyword) {
+			matched[kw.label] = true
+			fn(kw, matchType)
+		}
 	}
-	if c.tokenSecret == "" {
-		log.Fatalf("No Twitter access token secret provided, please set %s", envAccessSecret)
+	if s.Entities != nil {
+		for _, h := range s.Entities.Hashtags {
+			m.token(h.Text, found("hashtag"))
+		}
+		for _, u := range s.Entities.UserMentions {
+			m.token(u.ScreenName, found("mention"))
+		}
+		for _, u := range s.Entities.Urls {
+			for _, kw := range urlKeywords(m, u) {
+				found("url")(kw)
+			}
+		}
+	}
+	for _, c := range cashtags(s.Text) {
+		m.cashtag(c, found("cashtag"))
 	}
-	if c.consumerKey == "" {
-		log.Fatalf("No Twitter consumer key provided, please set %s", envConsumerKey)
+	m.text(s.Text, found("word"))
+	return matched
+}
+
+// mediaTypes are the types of media which can be attached to a tweet.
+var mediaTypes = map[string]bool{"photo": true, "video": true, "animated_gif": true}
+
+// countMedia counts each item of media attached to s by its type. Only the
+// extended entities list every item; the entities only have the first photo.
+func (e *Exporter) countMedia(s *twitter.Tweet, rt string) {
+	if s.ExtendedEntities == nil {
+		return
 	}
-	if c.consumerSecret == "" {
-		log.Fatalf("No Twitter consumer secret provided, please set %s", envConsumerSecret)
+	for _, m := range s.ExtendedEntities.Media {
+		t := m.Type
+		if !mediaTypes[t] {
+			t = "other"
+		}
+		e.media.WithLabelValues(e.series.values("twitter_stream_media_total", t, rt)...).Inc()
+	}
+}
+
+// stallWarning records a warning that Twitter's queue of messages for the
+// stream is filling up because they aren't being read quickly enough.
+func (e *Exporter) stallWarning(w *twitter.StallWarning) {
+	e.stallWarnings.Inc()
+	e.stallQueue.Set(float64(w.PercentFull))
+	logWarn("Stall warning from Twitter", "code", w.Code, "message", w.Message, "percent_full", w.PercentFull)
+}
+
+// disconnectReasons names the codes of disconnect messages.
+// https://dev.twitter.com/streaming/overview/messages-types#disconnect_messages
+var disconnectReasons = map[int64]string{
+	1:  "shutdown",
+	2:  "duplicate_stream",
+	3:  "control_request",
+	4:  "stall",
+	5:  "normal",
+	6:  "token_revoked",
+	7:  "admin_logout",
+	9:  "max_message_limit",
+	10: "stream_exception",
+	11: "broker_stall",
+	12: "shed_load",
+}
+
+// disconnect records a message from Twitter explaining why it's about to
+// close the stream.
+func (e *Exporter) disconnect(d *twitter.StreamDisconnect) {
+	reason, ok := disconnectReasons[d.Code]
+	if !ok {
+		reason = "unknown"
 	}
+	e.disconnects.WithLabelValues(e.series.values("twitter_stream_disconnects_total", strconv.FormatInt(d.Code, 10), reason)...).Inc()
+	logWarn("Twitter is disconnecting the stream", "code", d.Code, "reason", reason, "message", d.Reason)
+}
 
-	e, err := NewExporter(c)
+// sampled reports whether the tweet with the given ID is in the fraction
+// rate of tweets which are processed. The choice depends only on the ID, so
+// every exporter sampling at the same rate picks the same tweets.
+func sampled(id string, rate float64) bool {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return float64(h.Sum64()) < rate*math.MaxUint64
+}
+
+// isExcluded reports whether any of the tweet's hashtags, user mentions or
+// words are in the exclude set, folded in the same way as m's keywords.
+func isExcluded(t *twitter.Tweet, m *matcher, exclude map[string]bool) bool {
+	if len(exclude) == 0 {
+		return false
+	}
+	if t.Entities != nil {
+		for _, h := range t.Entities.Hashtags {
+			if exclude[m.fold(strings.ToLower(h.Text))] {
+				return true
+			}
+		}
+		for _, u := range t.Entities.UserMentions {
+			if exclude[m.fold(strings.ToLower(u.ScreenName))] {
+				return true
+			}
+		}
+	}
+	for _, w := range tokenize(m.fold(strings.ToLower(t.Text))) {
+		if exclude[w] {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	showVersion                = flag.Bool("version", false, "Print version information and exit.")
+	versionFormat              = flag.String("version.format", "text", "Format of the -version output: text or json.")
+	configFile                 = flag.String("config.file", "", "Path to an optional YAML configuration file. Flags override values from the file.")
+	mode                       = flag.String("twitter.mode", "filter", "Stream to consume: filter for tweets matching the tracked keywords, sample for a sample of all public tweets, or poll to search for the tracked keywords periodically.")
+	pollInterval               = flag.Duration("twitter.poll-interval", time.Minute, "How often to search for the tracked keywords in poll mode.")
+	track                      = flag.String("twitter.track", "", "Comma-separated list of keywords to track.")
+	exclude                    = flag.String("twitter.exclude", "", "Comma-separated list of terms. Tweets containing any of them are not counted.")
+	foldDiacritics             = flag.Bool("twitter.fold-diacritics", false, "Ignore accents when matching keywords, so that 'café' matches 'cafe'.")
+	follow                     = flag.String("twitter.follow", "", "Comma-separated list of user IDs whose tweets are also streamed.")
+	listID                     = flag.String("twitter.list-id", "", "ID of a list whose members' tweets are also streamed.")
+	listRefreshInterval        = flag.Duration("twitter.list-refresh-interval", 15*time.Minute, "How often to check the list given by -twitter.list-id for new members.")
+	locations                  = flag.String("twitter.locations", "", "Comma-separated bounding boxes whose tweets are also streamed, each given as south-west longitude,latitude then north-east longitude,latitude.")
+	languages                  = flag.String("twitter.languages", "", "Comma-separated list of BCP 47 language codes. Tweets in other languages are ignored.")
+	sampleRate                 = flag.Float64("twitter.sample-rate", 1, "Fraction of received tweets to count, between 0 and 1, to reduce CPU use on very busy streams.")
+	hashtagPairs               = flag.Bool("twitter.hashtag-pairs", false, "Count the other hashtags which appear in tweets matching each keyword.")
+	hashtagPairsMax            = flag.Int("twitter.hashtag-pairs.max", 1000, "Maximum number of distinct hashtags counted by -twitter.hashtag-pairs. Further hashtags are counted as __other__.")
+	hashtagPairsAllow          = flag.String("twitter.hashtag-pairs.allow", "", "Comma-separated list of hashtags. If set, only these are counted by -twitter.hashtag-pairs.")
+	trendingHashtags           = flag.Int("twitter.trending-hashtags", 0, "Number of the most frequent untracked hashtags to export as twitter_stream_trending_hashtags. 0 disables it.")
+	trendingHalfLife           = flag.Duration("twitter.trending-hashtags.half-life", time.Hour, "How quickly hashtags stop trending: their counts halve every half-life.")
+	linkDomains                = flag.Bool("twitter.link-domains", false, "Count the domains linked to by matching tweets.")
+	linkDomainsMax             = flag.Int("twitter.link-domains.max", 1000, "Maximum number of distinct domains counted by -twitter.link-domains. Further domains are counted as __other__.")
+	linkDomainsAllow           = flag.String("twitter.link-domains.allow", "", "Comma-separated list of domains. If set, only these are counted by -twitter.link-domains.")
+	linkDomainsDeny            = flag.String("twitter.link-domains.deny", "", "Comma-separated list of domains which are never counted by -twitter.link-domains.")
+	clientApps                 = flag.Bool("twitter.client-apps", false, "Count the client applications matching tweets were posted with.")
+	clientAppsMax              = flag.Int("twitter.client-apps.max", 100, "Maximum number of distinct applications counted by -twitter.client-apps. Further applications are counted as __other__.")
+	clientAppsAllow            = flag.String("twitter.client-apps.allow", "", "Comma-separated list of application names. If set, only these are counted separately by -twitter.client-apps and the rest are counted as __other__.")
+	profileMentions            = flag.Bool("twitter.profile-mentions", false, "Count keywords in the screen names, display names and bios of the authors of tweets.")
+	sentiment                  = flag.Bool("twitter.sentiment", false, "Score the sentiment of tweets matching each keyword with a built-in English word list.")
+	emoji                      = flag.Bool("twitter.emoji", false, "Count the emoji in matching tweets.")
+	emojiMax                   = flag.Int("twitter.emoji.max", 200, "Maximum number of distinct emoji counted by -twitter.emoji. Further emoji are counted as __other__.")
+	emojiAllow                 = flag.String("twitter.emoji.allow", "", "Comma-separated list of emoji. If set, only these are counted by -twitter.emoji.")
+	uniqueAuthors              = flag.Bool("twitter.unique-authors", false, "Estimate the number of distinct users posting tweets matching each keyword over the last 5 minutes and hour.")
+	botHeuristics              = flag.Bool("twitter.bot-heuristics", false, "Count tweets matching each keyword whose authors look automated.")
+	botMinSignals              = flag.Int("twitter.bot-heuristics.min-signals", 2, "Number of bot signals an author must show to be counted by -twitter.bot-heuristics.")
+	botMaxAccountAge           = flag.Duration("twitter.bot-heuristics.max-account-age", 30*24*time.Hour, "Accounts younger than this show a bot signal.")
+	botMinFollowerRatio        = flag.Float64("twitter.bot-heuristics.min-follower-ratio", 0.1, "Accounts following at least 50 others with fewer followers than this fraction of them show a bot signal.")
+	botSources                 = flag.String("twitter.bot-heuristics.sources", "", "Comma-separated list of client applications whose tweets show a bot signal.")
+	duplicates                 = flag.Bool("twitter.duplicates", false, "Count tweets matching each keyword whose text nearly duplicates a recent tweet's.")
+	duplicatesWindow           = flag.Duration("twitter.duplicates.window", 10*time.Minute, "How long tweets are remembered for by -twitter.duplicates. At most 10000 tweets are remembered.")
+	duplicatesExclude          = flag.Bool("twitter.duplicates.exclude", false, "Leave tweets detected by -twitter.duplicates out of the mention counters.")
+	countQuoted                = flag.Bool("twitter.count-quoted", false, "Also count keywords in quoted tweets, with the quoted label set to true.")
+	idleRestartAfter           = flag.Duration("twitter.idle-restart-after", 10*time.Minute, "Restart the stream if no data, including keep-alives, is received for this long. 0 disables the watchdog.")
+	proxyURL                   = flag.String("twitter.proxy-url", "", "HTTP, HTTPS or SOCKS5 proxy to connect to Twitter through, e.g. socks5://localhost:1080. Defaults to $HTTPS_PROXY.")
+	source                     = flag.String("source", "twitter", "Where to stream from: twitter, mastodon for the instance at -mastodon.url, bluesky for the firehose at -bluesky.url, or file to replay -source.file.")
+	sourceFile                 = flag.String("source.file", "", "File of newline-delimited tweet JSON to replay with -source=file.")
+	sourceRate                 = flag.Float64("source.rate", 0, "Tweets per second to replay from -source.file. 0 replays them as fast as possible.")
+	mastodonURL                = flag.String("mastodon.url", "", "Base URL of the Mastodon instance to stream from, e.g. https://mastodon.social.")
+	blueskyURL                 = flag.String("bluesky.url", "wss://jetstream2.us-east.bsky.network/subscribe", "URL of the Bluesky Jetstream firehose to subscribe to.")
+	blueskyPDS                 = flag.String("bluesky.pds-url", "https://bsky.social", "URL of the Bluesky server to log in to with the app password.")
+	recordPath                 = flag.String("record.path", "", "Directory to archive raw messages from the stream to, as gzipped JSONL files which can be replayed with -source=file.")
+	recordMaxSize              = flag.Int64("record.max-size", 100<<20, "Compressed size in bytes at which a new recording file is started.")
+	classifierURL              = flag.String("classifier.url", "", "URL of an HTTP service to POST the text of matching tweets to for labelling.")
+	classifierTimeout          = flag.Duration("classifier.timeout", 5*time.Second, "Timeout for requests to -classifier.url.")
+	classifierConcurrency      = flag.Int("classifier.concurrency", 4, "Maximum number of requests to -classifier.url in flight. Tweets arriving while this many are outstanding aren't classified.")
+	classifierMaxLabels        = flag.Int("classifier.max-labels", 100, "Maximum number of distinct labels from -classifier.url to export. Further labels are counted as __other__.")
+	recordMaxAge               = flag.Duration("record.max-age", time.Hour, "Age at which a new recording file is started.")
+	trackFile                  = flag.String("twitter.track-file", "", "File containing keywords to track, one per line. The file is watched with inotify and the stream is restarted when it changes.")
+	credentialsSource          = flag.String("credentials.source", "", "Where to read Twitter credentials from: env, vault, aws-secretsmanager or aws-ssm. Defaults to env.")
+	credentialsSecretID        = flag.String("credentials.secret-id", "", "Name or ARN of the AWS secret or SSM parameter holding Twitter credentials.")
+	credentialsRefreshInterval = flag.Duration("credentials.refresh-interval", 5*time.Minute, "How often to check the credential source for rotated credentials.")
+	vaultAddress               = flag.String("vault.address", "", "Address of the Vault server holding Twitter credentials. Defaults to $VAULT_ADDR.")
+	vaultPath                  = flag.String("vault.path", "", "Path of a Vault KV secret containing Twitter credentials, e.g. secret/data/twitter.")
+	metricsNamespace           = flag.String("metrics.namespace", "", "Prefix added to the names of all exported metrics.")
+	disableGoCollector         = flag.Bool("metrics.disable-go-collector", false, "Don't export the go_* metrics about the Go runtime.")
+	disableProcessCollector    = flag.Bool("metrics.disable-process-collector", false, "Don't export the process_* metrics about the exporter's CPU, memory and file descriptor use.")
+	metricsExpireAfter         = flag.Duration("metrics.expire-after", 0, "Remove label combinations, such as keywords and hashtags, which haven't been updated for this long. 0 keeps them forever.")
+	metricsMaxSeries           = flag.Int("metrics.max-series", 0, "Maximum number of label combinations for each labelled counter and histogram. Further combinations are counted with every label set to __overflow__. Gauges such as the trending hashtags are bounded by their own settings. 0 is unlimited.")
+	metricsConstLabels         = flag.String("metrics.const-labels", "", "Comma-separated name=value labels added to all exported metrics, e.g. env=prod,team=social.")
+	listenAddress              = flag.String("web.listen-address", ":19000", "Address to listen on for web interface and telemetry, or unix:// followed by the path of a Unix domain socket.")
+	systemdSocket              = flag.Bool("web.systemd-socket", false, "Serve on the socket passed by systemd socket activation instead of -web.listen-address.")
+	socketMode                 = flag.String("web.socket-mode", "0660", "Permissions of the socket created when -web.listen-address is a unix:// path.")
+	metricsPath                = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
+	logLevel                   = flag.String("log.level", "info", "Only log messages with the given severity or above: debug, info, warn or error. At debug, every keyword match is logged.")
+	logSampleMatches           = flag.Int("log.sample-matches", 0, "Log the keywords matched by every nth matching tweet, and how they matched, at info level. 0 disables it.")
+	logFormat                  = flag.String("log.format", "logfmt", "Format of log messages: logfmt or json.")
+	startupRetry               = flag.Bool("startup.retry", false, "If Twitter can't be reached to verify the credentials at startup, keep retrying with a backoff instead of exiting. Rejected credentials always exit.")
+	shutdownTimeout            = flag.Duration("web.shutdown-timeout", 30*time.Second, "How long to wait on shutdown for received tweets to be processed and in-flight requests, such as scrapes, to finish.")
+	startPaused                = flag.Bool("twitter.start-paused", false, "Start without connecting to the stream until it's resumed with POST /api/v1/stream/resume.")
+	readTimeout                = flag.Duration("web.read-timeout", 30*time.Second, "Maximum time to read an HTTP request, including the body. 0 is unlimited.")
+	writeTimeout               = flag.Duration("web.write-timeout", 0, "Maximum time to write an HTTP response, from the end of reading the request. 0 is unlimited. If set, /debug/tail streams are cut off after this long.")
+	idleTimeout                = flag.Duration("web.idle-timeout", 2*time.Minute, "How long to keep idle HTTP keep-alive connections open. 0 uses -web.read-timeout.")
+	maxHeaderBytes             = flag.Int("web.max-header-bytes", 64<<10, "Maximum size in bytes of HTTP request headers.")
+	maxRequests                = flag.Int("web.max-requests", 40, "Maximum number of concurrent scrapes of the metrics. Further scrapes get 503 Service Unavailable. 0 is unlimited.")
+	webConfigFile              = flag.String("web.config.file", "", "Path to a Prometheus exporter-toolkit web configuration file enabling TLS and basic auth.")
+	readyMaxSilence            = flag.Duration("web.ready.max-silence", 5*time.Minute, "How long the stream may go without receiving data, including keep-alives, before /-/ready reports the exporter as not ready.")
+)
+
+func main() {
+	// Commands may be given before or after the flags.
+	var cmd string
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	if cmd == "" && flag.NArg() > 0 {
+		cmd = flag.Arg(0)
+	}
+	if err := setupLogging(*logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *showVersion {
+		os.Exit(printVersion(*versionFormat))
+	}
+	switch cmd {
+	case "":
+	case "check-config":
+		os.Exit(checkConfig())
+	case "test-auth":
+		os.Exit(testAuth())
+	default:
+		logFatal("Unknown command", "command", cmd)
+	}
+
+	c, err := loadConfig()
 	if err != nil {
-		log.Fatal(err)
+		logFatal("Error loading configuration", "err", err)
+	}
+
+	var rec *recorder
+	if c.recordPath != "" {
+		if rec, err = newRecorder(c.recordPath, c.recordMaxSize, c.recordMaxAge); err != nil {
+			logFatal("Error opening recording", "err", err)
+		}
+	}
+	if c.twitter.source == "twitter" {
+		if err := verifyAtStartup(c.twitter, c.startupRetry); err != nil {
+			logFatal("Error verifying Twitter credentials", "err", err)
+		}
+	}
+	e, err := NewExporter(c.twitter, c.metrics, rec)
+	if err != nil {
+		logFatal("Error starting exporter", "err", err)
+	}
+	// A registry of our own keeps the endpoint to what's registered here,
+	// whatever else registers with the default one.
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(e)
+	if !c.metrics.disableGoCollector {
+		reg.MustRegister(prometheus.NewGoCollector())
+	}
+	if !c.metrics.disableProcessCollector {
+		reg.MustRegister(prometheus.NewProcessCollector(os.Getpid(), ""))
 	}
-	prometheus.MustRegister(e)
 
 	bi := prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "twitter_stream_exporter_build_info",
-		Help: "twitter_stream exporter build info.",
+		Namespace:   c.metrics.namespace,
+		ConstLabels: c.metrics.constLabels,
+		Name:        "twitter_stream_exporter_build_info",
+		Help:        "twitter_stream exporter build info.",
 	}, []string{"version", "commit_sha", "build_date", "golang_version"})
-	prometheus.MustRegister(bi)
+	reg.MustRegister(bi)
 	bi.WithLabelValues(Version, CommitSHA1, BuildDate, runtime.Version()).Set(1)
 
-	log.Printf("Starting twitter_stream_exporter %s (build date: %s) (sha1: %s)\n", Version, BuildDate, CommitSHA1)
-	log.Printf("Metrics are avaiable at %s%s", *listenAddress, *metricsPath)
+	logInfo("Starting twitter_stream_exporter", "version", Version, "build_date", BuildDate, "sha1", CommitSHA1)
+	logInfo("Metrics are available", "address", c.listenAddress, "path", c.metricsPath)
 
-	http.Handle(*metricsPath, promhttp.Handler())
-	s := &http.Server{Addr: *listenAddress}
+	http.Handle(c.metricsPath, limitRequests(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}), c.maxRequests))
+	token := os.Getenv(envReloadToken)
+	if token != "" {
+		http.Handle("/-/reload", reloadHandler(e, token))
+	}
+	http.Handle("/api/v1/keywords", keywordsHandler(e, token))
+	http.Handle("/api/v1/status", statusHandler(e, c.readyMaxSilence))
+	http.Handle("/debug/tail", tailHandler(e.tail, token))
+	http.Handle("/api/v1/stream/pause", pauseHandler(e, token, true))
+	http.Handle("/api/v1/stream/resume", pauseHandler(e, token, false))
+	if c.metricsPath != "/" {
+		http.Handle("/", landingHandler(e, c.metricsPath, c.readyMaxSilence))
+	}
+	http.Handle("/-/healthy", healthyHandler())
+	http.Handle("/-/ready", readyHandler(e, c.readyMaxSilence))
+	s := &http.Server{
+		Addr:           c.listenAddress,
+		ReadTimeout:    c.readTimeout,
+		WriteTimeout:   c.writeTimeout,
+		IdleTimeout:    c.idleTimeout,
+		MaxHeaderBytes: c.maxHeaderBytes,
+	}
+	s.RegisterOnShutdown(e.tail.close)
+	if c.webConfigFile != "" {
+		wc, err := loadWebConfig(c.webConfigFile)
+		if err != nil {
+			logFatal("Error loading web configuration", "err", err)
+		}
+		wc.configureServer(s)
+	}
+	var l net.Listener
+	if c.systemdSocket {
+		l, err = systemdListener()
+	} else {
+		l, err = listen(c.listenAddress, c.socketMode)
+	}
+	if err != nil {
+		logFatal("Error listening", "address", c.listenAddress, "err", err)
+	}
 	go func() {
-		log.Print(s.ListenAndServe())
+		var err error
+		if s.TLSConfig != nil {
+			err = s.ServeTLS(l, "", "")
+		} else {
+			err = s.Serve(l)
+		}
+		if err != http.ErrServerClosed {
+			logError("HTTP server stopped", "err", err)
+		}
 	}()
 
-	ch := make(chan os.Signal)
-	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
-	<-ch
-	log.Println("Shutting down")
-	e.stream.Stop()
-	s.Close()
+	if c.trackFile != "" {
+		go watchTrackFile(c.trackFile, func() { logReload(e) })
+	}
+
+	if c.idleRestartAfter > 0 {
+		go e.watchIdle(c.idleRestartAfter)
+	}
+
+	if c.listRefreshInterval > 0 {
+		go e.watchList(c.listRefreshInterval)
+	}
+
+	src, err := newCredentialSource(c)
+	if err != nil {
+		logFatal("Error reading credentials", "err", err)
+	}
+	if src != nil {
+		go watchCredentials(src, c.credentialRefreshInterval, func() { logReload(e) })
+	}
+
+	if err := sdNotify("READY=1"); err != nil {
+		logWarn("Error notifying systemd", "err", err)
+	}
+	if wd := systemdWatchdogInterval(); wd > 0 {
+		go e.feedWatchdog(wd, c.readyMaxSilence)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGINT, syscall.SIGTERM)
+	for {
+		select {
+		case <-hup:
+			logInfo("Reloading configuration")
+			logReload(e)
+		case <-term:
+			logInfo("Shutting down", "timeout", c.shutdownTimeout)
+			sdNotify("STOPPING=1")
+			ctx, cancel := context.WithTimeout(context.Background(), c.shutdownTimeout)
+			e.Stop()
+			if err := e.Drain(ctx); err != nil {
+				logWarn("Gave up waiting for received tweets to be processed", "err", err)
+			}
+			if rec != nil {
+				if err := rec.Close(); err != nil {
+					logError("Error closing recording", "err", err)
+				}
+			}
+			if err := s.Shutdown(ctx); err != nil {
+				logWarn("Gave up waiting for HTTP requests to finish", "err", err)
+				s.Close()
+			}
+			cancel()
+			return
+		}
+	}
 }
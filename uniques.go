@@ -0,0 +1,172 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// hllPrecision is the number of hash bits which pick a HyperLogLog
+// register. With 2048 registers estimates are within 2.3% of the true count
+// with 68% confidence, in 2KiB per sketch.
+const hllPrecision = 11
+
+// hll is a HyperLogLog sketch estimating the number of distinct values
+// added to it.
+// http://algo.inria.fr/flajolet/Publications/FlFuGaMe07.pdf
+type hll [1 << hllPrecision]uint8
+
+// hashValue returns a well-mixed 64-bit hash of s. FNV alone mixes short
+// strings poorly, so its result is finished with SplitMix64's mixer.
+func hashValue(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// add records the value with hash x.
+func (h *hll) add(x uint64) {
+	i := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > h[i] {
+		h[i] = rank
+	}
+}
+
+// merge adds the values recorded in o to h.
+func (h *hll) merge(o *hll) {
+	for i, r := range o {
+		if r > h[i] {
+			h[i] = r
+		}
+	}
+}
+
+// count returns the estimated number of distinct values in h, using linear
+// counting for small cardinalities where HyperLogLog is biased.
+func (h *hll) count() float64 {
+	m := float64(len(h))
+	var sum float64
+	zeros := 0
+	for _, r := range h {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	est := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		return m * math.Log(m/float64(zeros))
+	}
+	return est
+}
+
+// slidingHLL estimates the distinct values added within a sliding window,
+// by keeping a sketch for each of several slices of the window. The window
+// covers the current slice and the slices-1 before it, so it's accurate to
+// within one slice.
+type slidingHLL struct {
+	width  time.Duration
+	slices []hll
+	epochs []int64
+}
+
+func newSlidingHLL(window time.Duration, slices int) *slidingHLL {
+	return &slidingHLL{width: window / time.Duration(slices), slices: make([]hll, slices), epochs: make([]int64, slices)}
+}
+
+// add records the value with hash x at time now.
+func (s *slidingHLL) add(x uint64, now time.Time) {
+	epoch := now.UnixNano() / int64(s.width)
+	i := int(epoch % int64(len(s.slices)))
+	if s.epochs[i] != epoch {
+		s.slices[i] = hll{}
+		s.epochs[i] = epoch
+	}
+	s.slices[i].add(x)
+}
+
+// count returns the estimated number of distinct values added within the
+// window ending at now.
+func (s *slidingHLL) count(now time.Time) float64 {
+	epoch := now.UnixNano() / int64(s.width)
+	var merged hll
+	for i := range s.slices {
+		if epoch-s.epochs[i] < int64(len(s.slices)) {
+			merged.merge(&s.slices[i])
+		}
+	}
+	return merged.count()
+}
+
+// uniqueWindows are the windows unique authors are counted over, each
+// divided into the given number of slices.
+var uniqueWindows = []struct {
+	name   string
+	window time.Duration
+	slices int
+}{
+	{"5m", 5 * time.Minute, 5},
+	{"1h", time.Hour, 12},
+}
+
+// authorCounter estimates the number of distinct authors of the tweets
+// matching each keyword, without storing their IDs.
+type authorCounter struct {
+	mtx      sync.Mutex
+	keywords map[string][]*slidingHLL
+}
+
+func newAuthorCounter() *authorCounter {
+	return &authorCounter{keywords: map[string][]*slidingHLL{}}
+}
+
+// add records that the user with ID id posted a tweet matching keyword.
+func (u *authorCounter) add(keyword, id string) {
+	x := hashValue(id)
+	now := time.Now()
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	windows, ok := u.keywords[keyword]
+	if !ok {
+		for _, w := range uniqueWindows {
+			windows = append(windows, newSlidingHLL(w.window, w.slices))
+		}
+		u.keywords[keyword] = windows
+	}
+	for _, w := range windows {
+		w.add(x, now)
+	}
+}
+
+// prune forgets the keywords which are no longer tracked.
+func (u *authorCounter) prune(keywords map[string]bool) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	for kw := range u.keywords {
+		if !keywords[kw] {
+			delete(u.keywords, kw)
+		}
+	}
+}
+
+// each calls fn with the estimated number of unique authors of each keyword
+// in each window.
+func (u *authorCounter) each(fn func(keyword, window string, count float64)) {
+	now := time.Now()
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	for kw, windows := range u.keywords {
+		for i, w := range windows {
+			fn(kw, uniqueWindows[i].name, w.count(now))
+		}
+	}
+}
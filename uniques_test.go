@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// hllTolerance is three times the standard error of an estimate, 1.04/√m.
+var hllTolerance = 3 * 1.04 / math.Sqrt(1<<hllPrecision)
+
+func TestHLLCount(t *testing.T) {
+	// Counts either side of 2.5m, where count switches from linear counting
+	// to the HyperLogLog estimate, as well as well below and above it.
+	for _, n := range []int{1, 10, 100, 1000, 5000, 5500, 10000, 100000, 1000000} {
+		var h hll
+		for i := 0; i < n; i++ {
+			h.add(hashValue("user" + strconv.Itoa(i)))
+			// Values seen again don't change the estimate.
+			h.add(hashValue("user" + strconv.Itoa(i/2)))
+		}
+		got := h.count()
+		if err := math.Abs(got-float64(n)) / float64(n); err > hllTolerance {
+			t.Errorf("%d values: got estimate %.0f, %.1f%% out, want within %.1f%%", n, got, err*100, hllTolerance*100)
+		}
+	}
+
+	var h hll
+	if got := h.count(); got != 0 {
+		t.Errorf("empty sketch: got estimate %v, want 0", got)
+	}
+}
+
+func TestHLLRank(t *testing.T) {
+	for _, tt := range []struct {
+		x        uint64
+		register int
+		rank     uint8
+	}{
+		{0, 0, 64 - hllPrecision + 1},
+		{1, 0, 64 - hllPrecision},
+		{1 << (63 - hllPrecision), 0, 1},
+		{1<<63 | 1<<(62-hllPrecision), 1 << (hllPrecision - 1), 2},
+		{math.MaxUint64, 1<<hllPrecision - 1, 1},
+	} {
+		var h hll
+		h.add(tt.x)
+		for i, r := range h {
+			want := uint8(0)
+			if i == tt.register {
+				want = tt.rank
+			}
+			if r != want {
+				t.Errorf("add(%#x): register %d is %d, want %d", tt.x, i, r, want)
+			}
+		}
+	}
+}
+
+func TestHLLMerge(t *testing.T) {
+	var a, b hll
+	for i := 0; i < 20000; i++ {
+		a.add(hashValue("a" + strconv.Itoa(i)))
+		b.add(hashValue("b" + strconv.Itoa(i)))
+		// Shared by both.
+		if i < 10000 {
+			a.add(hashValue("c" + strconv.Itoa(i)))
+			b.add(hashValue("c" + strconv.Itoa(i)))
+		}
+	}
+	a.merge(&b)
+	if got := a.count(); math.Abs(got-50000)/50000 > hllTolerance {
+		t.Errorf("got merged estimate %.0f, want 50000 within %.1f%%", got, hllTolerance*100)
+	}
+}
+
+func TestSlidingHLL(t *testing.T) {
+	s := newSlidingHLL(5*time.Minute, 5)
+	start := time.Unix(1500000000, 0)
+	for i := 0; i < 1000; i++ {
+		s.add(hashValue("old"+strconv.Itoa(i)), start)
+		s.add(hashValue("new"+strconv.Itoa(i)), start.Add(3*time.Minute))
+	}
+	for _, tt := range []struct {
+		at   time.Duration
+		want float64
+	}{
+		{4 * time.Minute, 2000},
+		// The first slice has left the window.
+		{6 * time.Minute, 1000},
+		{20 * time.Minute, 0},
+	} {
+		got := s.count(start.Add(tt.at))
+		if math.Abs(got-tt.want) > tt.want*hllTolerance {
+			t.Errorf("after %v: got estimate %.0f, want %.0f", tt.at, got, tt.want)
+		}
+	}
+}
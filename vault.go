@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	envVaultAddress = "VAULT_ADDR"
+	envVaultToken   = "VAULT_TOKEN"
+)
+
+// vaultClient reads credentials from a HashiCorp Vault KV secret.
+type vaultClient struct {
+	address string
+	path    string
+	token   string
+	client  *http.Client
+}
+
+// newVaultClient returns a client for the secret at path on the Vault server
+// at address, falling back to VAULT_ADDR. The token is read from VAULT_TOKEN
+// or VAULT_TOKEN_FILE.
+func newVaultClient(address, path string) (*vaultClient, error) {
+	if address == "" {
+		address = os.Getenv(envVaultAddress)
+	}
+	if address == "" {
+		return nil, fmt.Errorf("No Vault address provided, please set -vault.address or %s", envVaultAddress)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("No Vault secret path provided, please set -vault.path")
+	}
+	token, err := credential(envVaultToken, "")
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, fmt.Errorf("No Vault token provided, please set %s or %s_FILE", envVaultToken, envVaultToken)
+	}
+	return &vaultClient{
+		address: strings.TrimRight(address, "/"),
+		path:    strings.Trim(path, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// do sends a request to the Vault API and decodes the JSON response into v.
+func (v *vaultClient) do(method, path string, out interface{}) error {
+	req, err := http.NewRequest(method, v.address+"/v1/"+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("vault returned %s for %s: %s", resp.Status, path, strings.Join(e.Errors, ", "))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// fetch implements credentialSource. Both version 1 and version 2 KV engines
+// are supported, though only the latter reports a version.
+func (v *vaultClient) fetch() (map[string]string, string, error) {
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := v.do(http.MethodGet, v.path, &resp); err != nil {
+		return nil, "", err
+	}
+	data := resp.Data
+	var version string
+	// KV version 2 nests the secret alongside its metadata.
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if md, ok := data["metadata"].(map[string]interface{}); ok {
+			data = inner
+			version = fmt.Sprint(md["version"])
+		}
+	}
+	secret := map[string]string{}
+	for k, val := range data {
+		if s, ok := val.(string); ok {
+			secret[k] = s
+		}
+	}
+	return secret, version, nil
+}
+
+// renew extends the lease on the client's own token.
+func (v *vaultClient) renew() error {
+	var resp interface{}
+	return v.do(http.MethodPost, "auth/token/renew-self", &resp)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dghubble/go-twitter/twitter"
+)
+
+// maxClockSkew is how far the system clock may differ from Twitter's before
+// a rejected request is blamed on it. OAuth 1.0a signatures include a
+// timestamp which Twitter rejects if it's too far out.
+const maxClockSkew = 5 * time.Minute
+
+// credentialError explains why Twitter rejected the credentials.
+type credentialError struct {
+	reason string
+	err    error
+	// transient is set for failures which may succeed if retried, such as
+	// network errors and Twitter being over capacity.
+	transient bool
+}
+
+func (e *credentialError) Error() string {
+	return fmt.Sprintf("%s: %v", e.reason, e.err)
+}
+
+// twitterErrorReasons explain Twitter's error codes for authentication
+// failures.
+// https://developer.twitter.com/en/support/twitter-api/error-troubleshooting
+var twitterErrorReasons = map[int]string{
+	32:  "Twitter couldn't authenticate the request; check the consumer key and secret",
+	89:  "The access token is invalid or has been revoked; check the access token and secret",
+	99:  "Twitter couldn't verify the app-only credentials; check the bearer token",
+	135: "Twitter rejected the request's timestamp; check that the system clock is correct",
+	215: "Twitter received bad authentication data; check that all of the credentials are set",
+	326: "The account is locked; log in to Twitter to unlock it",
+}
+
+// verifyCredentials checks the Twitter credentials in c, returning the
+// account they belong to. App-only tokens don't belong to an account, so
+// they're checked with a search instead and no user is returned.
+func verifyCredentials(c twitterConfig) (*twitter.User, *http.Response, error) {
+	client := getTwitterClient(c)
+	var u *twitter.User
+	var resp *http.Response
+	var err error
+	if c.bearerToken != "" {
+		_, resp, err = client.Search.Tweets(&twitter.SearchTweetParams{Query: "twitter", Count: 1})
+	} else {
+		u, resp, err = client.Accounts.VerifyCredentials(&twitter.AccountVerifyParams{
+			SkipStatus: twitter.Bool(true),
+		})
+	}
+	if err != nil {
+		return nil, resp, explainCredentialError(err, resp)
+	}
+	return u, resp, nil
+}
+
+// explainCredentialError wraps an error from verifying credentials with the
+// most likely reason for it.
+func explainCredentialError(err error, resp *http.Response) error {
+	if resp == nil {
+		return &credentialError{reason: "Couldn't reach Twitter", err: err, transient: true}
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		if date, derr := http.ParseTime(resp.Header.Get("Date")); derr == nil {
+			if skew := time.Since(date); skew > maxClockSkew || skew < -maxClockSkew {
+				return &credentialError{reason: fmt.Sprintf("The system clock differs from Twitter's by %s; correct it", skew.Round(time.Second)), err: err}
+			}
+		}
+	}
+	if apiErr, ok := err.(twitter.APIError); ok && !apiErr.Empty() {
+		if reason, ok := twitterErrorReasons[apiErr.Errors[0].Code]; ok {
+			return &credentialError{reason: reason, err: err}
+		}
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return &credentialError{reason: fmt.Sprintf("Twitter rejected the credentials with %s", resp.Status), err: err}
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return &credentialError{reason: fmt.Sprintf("Twitter is unavailable (%s)", resp.Status), err: err, transient: true}
+	}
+	return &credentialError{reason: fmt.Sprintf("Twitter returned %s", resp.Status), err: err}
+}
+
+// verifyAtStartup checks the Twitter credentials in c before the stream is
+// opened, so that bad credentials fail clearly rather than in a reconnection
+// loop. With retry, transient failures are retried with a backoff until
+// they succeed.
+func verifyAtStartup(c twitterConfig, retry bool) error {
+	b := newBackOff(5*time.Second, 5*time.Minute)
+	for {
+		u, _, err := verifyCredentials(c)
+		if err == nil {
+			if u != nil {
+				logInfo("Verified Twitter credentials", "user", u.ScreenName)
+			} else {
+				logInfo("Verified Twitter app-only credentials")
+			}
+			return nil
+		}
+		if ce, ok := err.(*credentialError); !retry || !ok || !ce.transient {
+			return err
+		}
+		wait := b.NextBackOff()
+		logWarn("Error verifying Twitter credentials, retrying", "err", err, "wait", wait)
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// webConfig is the Prometheus exporter-toolkit web configuration file read
+// from -web.config.file, so that the same files can be shared with other
+// exporters. It has the settings of exporter-toolkit 0.5, and unknown
+// settings are rejected rather than ignored.
+// https://github.com/prometheus/exporter-toolkit/blob/master/docs/web-configuration.md
+type webConfig struct {
+	TLSServerConfig struct {
+		CertFile                 string   `yaml:"cert_file"`
+		KeyFile                  string   `yaml:"key_file"`
+		ClientAuthType           string   `yaml:"client_auth_type"`
+		ClientCAFile             string   `yaml:"client_ca_file"`
+		CipherSuites             []string `yaml:"cipher_suites"`
+		CurvePreferences         []string `yaml:"curve_preferences"`
+		MinVersion               string   `yaml:"min_version"`
+		MaxVersion               string   `yaml:"max_version"`
+		PreferServerCipherSuites *bool    `yaml:"prefer_server_cipher_suites"`
+	} `yaml:"tls_server_config"`
+	HTTPServerConfig struct {
+		// HTTP2 is nil unless set, in which case it defaults to true.
+		HTTP2 *bool `yaml:"http2"`
+	} `yaml:"http_server_config"`
+	// BasicAuthUsers maps usernames to bcrypt hashes of their passwords.
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users"`
+
+	tls *tls.Config
+
+	mtx sync.Mutex
+	// authenticated caches credentials which have matched, as checking a
+	// bcrypt hash is deliberately slow.
+	authenticated map[[sha256.Size]byte]bool
+}
+
+var tlsClientAuthTypes = map[string]tls.ClientAuthType{
+	"":                           tls.NoClientCert,
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"CurveP256": tls.CurveP256,
+	"CurveP384": tls.CurveP384,
+	"CurveP521": tls.CurveP521,
+	"X25519":    tls.X25519,
+}
+
+// dummyBcryptHash is checked against the password of unknown users, so that
+// they take as long to reject as known users with the wrong password.
+var dummyBcryptHash = []byte("$2b$10$d4n4rlJuy9OoqEzR8d5MWeCFRnNOeA7sE3YcVm8570Clcr6QUaymS")
+
+// loadWebConfig reads and checks the web configuration file at path.
+// Relative paths in the file are relative to its directory.
+func loadWebConfig(path string) (*webConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	wc := &webConfig{authenticated: map[[sha256.Size]byte]bool{}}
+	if err := unmarshalYAML(b, wc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	for user, hash := range wc.BasicAuthUsers {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("password for basic auth user %q in %s is not a bcrypt hash: %v", user, path, err)
+		}
+	}
+	c := &wc.TLSServerConfig
+	for _, f := range []*string{&c.CertFile, &c.KeyFile, &c.ClientCAFile} {
+		if *f != "" && !filepath.IsAbs(*f) {
+			*f = filepath.Join(filepath.Dir(path), *f)
+		}
+	}
+	if wc.tls, err = wc.tlsConfig(); err != nil {
+		return nil, fmt.Errorf("tls_server_config in %s: %v", path, err)
+	}
+	return wc, nil
+}
+
+// tlsConfig returns the TLS configuration for the server, or nil if it
+// should serve plain HTTP.
+func (wc *webConfig) tlsConfig() (*tls.Config, error) {
+	c := wc.TLSServerConfig
+	if c.CertFile == "" && c.KeyFile == "" {
+		if c.ClientAuthType != "" || c.ClientCAFile != "" {
+			return nil, fmt.Errorf("client certificates require cert_file and key_file")
+		}
+		return nil, nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, fmt.Errorf("cert_file and key_file must both be set")
+	}
+	// Check the certificate now rather than on the first connection.
+	if _, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile); err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:               tls.VersionTLS12,
+		MaxVersion:               tls.VersionTLS13,
+		PreferServerCipherSuites: c.PreferServerCipherSuites == nil || *c.PreferServerCipherSuites,
+		// Reading the certificate for each connection picks up renewals
+		// without a restart.
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+			return &cert, nil
+		},
+	}
+	var ok bool
+	if cfg.ClientAuth, ok = tlsClientAuthTypes[c.ClientAuthType]; !ok {
+		return nil, fmt.Errorf("unknown client_auth_type %q", c.ClientAuthType)
+	}
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client_ca_file %s", c.ClientCAFile)
+		}
+	} else if cfg.ClientAuth == tls.VerifyClientCertIfGiven || cfg.ClientAuth == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("client_auth_type %s requires client_ca_file", c.ClientAuthType)
+	}
+	if c.MinVersion != "" {
+		if cfg.MinVersion, ok = tlsVersions[c.MinVersion]; !ok {
+			return nil, fmt.Errorf("unknown min_version %q", c.MinVersion)
+		}
+	}
+	if c.MaxVersion != "" {
+		if cfg.MaxVersion, ok = tlsVersions[c.MaxVersion]; !ok {
+			return nil, fmt.Errorf("unknown max_version %q", c.MaxVersion)
+		}
+	}
+	if cfg.MinVersion > cfg.MaxVersion {
+		return nil, fmt.Errorf("min_version is newer than max_version")
+	}
+	if len(c.CipherSuites) > 0 {
+		suites := map[string]uint16{}
+		for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[s.Name] = s.ID
+		}
+		for _, name := range c.CipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown cipher suite %q", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+	for _, name := range c.CurvePreferences {
+		id, ok := tlsCurves[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		cfg.CurvePreferences = append(cfg.CurvePreferences, id)
+	}
+	return cfg, nil
+}
+
+// configureServer applies the configuration to s.
+func (wc *webConfig) configureServer(s *http.Server) {
+	h := s.Handler
+	if h == nil {
+		h = http.DefaultServeMux
+	}
+	s.Handler = wc.handler(h)
+	s.TLSConfig = wc.tls
+	// A non-nil TLSNextProto without "h2" stops the server offering HTTP/2.
+	if h := wc.HTTPServerConfig.HTTP2; h != nil && !*h {
+		s.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+}
+
+// handler wraps h to require one of the basic auth users, if any are
+// configured.
+func (wc *webConfig) handler(h http.Handler) http.Handler {
+	if len(wc.BasicAuthUsers) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || !wc.checkPassword(user, pass) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="twitter_stream_exporter"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// checkPassword reports whether pass is the password of user.
+func (wc *webConfig) checkPassword(user, pass string) bool {
+	key := sha256.Sum256([]byte(user + "\x00" + pass))
+	wc.mtx.Lock()
+	cached := wc.authenticated[key]
+	wc.mtx.Unlock()
+	if cached {
+		return true
+	}
+
+	hash, ok := wc.BasicAuthUsers[user]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyBcryptHash, []byte(pass))
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+		return false
+	}
+	wc.mtx.Lock()
+	wc.authenticated[key] = true
+	wc.mtx.Unlock()
+	return true
+}
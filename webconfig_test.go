@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeWebConfig writes body to web.yml in a new directory, returning its
+// path and a function removing the directory.
+func writeWebConfig(t *testing.T, body string) (string, func()) {
+	dir, err := ioutil.TempDir("", "webconfig_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "web.yml")
+	if err := ioutil.WriteFile(path, []byte(body), 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+// writeCertificate writes a self-signed certificate and its key to
+// cert.pem and key.pem in dir.
+func writeCertificate(t *testing.T, dir string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, block := range map[string]*pem.Block{
+		"cert.pem": {Type: "CERTIFICATE", Bytes: der},
+		"key.pem":  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWebConfigBasicAuth(t *testing.T) {
+	// Hashes from OpenBSD's and x/crypto's bcrypt tests.
+	path, cleanup := writeWebConfig(t, `basic_auth_users:
+  alice: $2b$04$abcdefghijklmnopqrstuuMFdJu9yVgmagVAIC24fOZkaFqd3s9JC
+  bob: $2a$06$DCq7YPn5Rq63x1Lad4cll.TV4S6ytwfsfvkgY8jIucDrjc8deX1s.
+  carol: $2a$10$XajjQvNhvvRt5GSeFk1xFeyqRrsxkhBkUiQeg0dt.wU1qD4aFDcga
+`)
+	defer cleanup()
+	wc, err := loadWebConfig(path)
+	if err != nil {
+		t.Fatalf("loadWebConfig: %v", err)
+	}
+	if wc.tls != nil {
+		t.Errorf("got a TLS configuration without tls_server_config")
+	}
+
+	for _, tt := range []struct {
+		user, pass string
+		want       bool
+	}{
+		{"alice", "a", true},
+		{"alice", "b", false},
+		{"alice", "", false},
+		{"bob", "", true},
+		{"bob", "a", false},
+		{"carol", "allmine", true},
+		{"carol", "allmine\x00", false},
+		{"dave", "a", false},
+		// The cache of passwords which have matched mustn't let one user's
+		// password authenticate another.
+		{"bob", "allmine", false},
+	} {
+		if got := wc.checkPassword(tt.user, tt.pass); got != tt.want {
+			t.Errorf("checkPassword(%q, %q) = %v, want %v", tt.user, tt.pass, got, tt.want)
+		}
+	}
+
+	h := wc.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metrics"))
+	}))
+	for _, tt := range []struct {
+		user, pass string
+		auth       bool
+		want       int
+	}{
+		{auth: false, want: http.StatusUnauthorized},
+		{user: "alice", pass: "b", auth: true, want: http.StatusUnauthorized},
+		{user: "alice", pass: "a", auth: true, want: http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if tt.auth {
+			req.SetBasicAuth(tt.user, tt.pass)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("user %q password %q: got status %d, want %d", tt.user, tt.pass, w.Code, tt.want)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("user %q password %q: 401 response has no WWW-Authenticate header", tt.user, tt.pass)
+		}
+	}
+}
+
+func TestWebConfigTLS(t *testing.T) {
+	path, cleanup := writeWebConfig(t, `tls_server_config:
+  cert_file: cert.pem
+  key_file: key.pem
+  min_version: TLS12
+  max_version: TLS12
+  cipher_suites:
+    - TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+  curve_preferences: [X25519, CurveP256]
+  prefer_server_cipher_suites: false
+http_server_config:
+  http2: false
+`)
+	defer cleanup()
+	// The certificate is relative to the configuration file, not the working
+	// directory.
+	writeCertificate(t, filepath.Dir(path))
+
+	wc, err := loadWebConfig(path)
+	if err != nil {
+		t.Fatalf("loadWebConfig: %v", err)
+	}
+	cfg := wc.tls
+	if cfg == nil {
+		t.Fatal("got no TLS configuration")
+	}
+	if cfg.MinVersion != tls.VersionTLS12 || cfg.MaxVersion != tls.VersionTLS12 {
+		t.Errorf("got versions %x-%x, want TLS 1.2 only", cfg.MinVersion, cfg.MaxVersion)
+	}
+	if len(cfg.CipherSuites) != 1 || cfg.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("got cipher suites %x", cfg.CipherSuites)
+	}
+	if len(cfg.CurvePreferences) != 2 || cfg.CurvePreferences[0] != tls.X25519 || cfg.CurvePreferences[1] != tls.CurveP256 {
+		t.Errorf("got curves %v", cfg.CurvePreferences)
+	}
+	if cfg.PreferServerCipherSuites {
+		t.Errorf("prefer_server_cipher_suites: false was ignored")
+	}
+	if _, err := cfg.GetCertificate(&tls.ClientHelloInfo{}); err != nil {
+		t.Errorf("GetCertificate: %v", err)
+	}
+
+	s := &http.Server{}
+	wc.configureServer(s)
+	if s.TLSConfig != cfg {
+		t.Errorf("server doesn't use the TLS configuration")
+	}
+	if s.TLSNextProto == nil || len(s.TLSNextProto) != 0 {
+		t.Errorf("http2: false didn't disable HTTP/2")
+	}
+}
+
+func TestLoadWebConfigErrors(t *testing.T) {
+	for _, tt := range []struct {
+		body string
+		want string
+	}{
+		// Unknown settings, including ones from newer versions of
+		// exporter-toolkit, mustn't be silently ignored.
+		{"tls_config:\n  cert_file: cert.pem\n", "field tls_config not found"},
+		{"tls_server_config:\n  client_allowed_sans: [example.com]\n", "field client_allowed_sans not found"},
+		{"http_server_config:\n  headers:\n    X-Frame-Options: deny\n", "field headers not found"},
+		{"basic_auth_users:\n  alice: hunter2\n", `basic auth user "alice"`},
+		{"basic_auth_users:\n  alice: $2a$32$sssssssssssssssssssssshhhhhhhhhhhhhhhhhhhhhhhhhhhhhhh\n", `basic auth user "alice"`},
+		{"tls_server_config:\n  cert_file: cert.pem\n", "cert_file and key_file must both be set"},
+		{"tls_server_config:\n  client_auth_type: RequireAndVerifyClientCert\n", "require cert_file and key_file"},
+	} {
+		path, cleanup := writeWebConfig(t, tt.body)
+		_, err := loadWebConfig(path)
+		cleanup()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got error %v, want one containing %q", tt.body, err, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		tls  string
+		want string
+	}{
+		{"client_auth_type: Sometimes", `unknown client_auth_type "Sometimes"`},
+		{"min_version: SSL30", `unknown min_version "SSL30"`},
+		{"min_version: TLS13\n  max_version: TLS12", "min_version is newer than max_version"},
+		{"cipher_suites: [TLS_RSA_WITH_RC5]", `unknown cipher suite "TLS_RSA_WITH_RC5"`},
+		{"curve_preferences: [CurveP224]", `unknown curve "CurveP224"`},
+	} {
+		path, cleanup := writeWebConfig(t, "tls_server_config:\n  cert_file: cert.pem\n  key_file: key.pem\n  "+tt.tls+"\n")
+		writeCertificate(t, filepath.Dir(path))
+		_, err := loadWebConfig(path)
+		cleanup()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got error %v, want one containing %q", tt.tls, err, tt.want)
+		}
+	}
+}
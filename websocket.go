@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/websocket"
+)
+
+// maxWebSocketMessage is the size of the largest message which is read.
+const maxWebSocketMessage = 4 << 20
+
+// dialWebSocket opens a WebSocket connection to u, a ws or wss URL, sending
+// the extra handshake headers in header. The connection is made through the
+// proxy at proxyURL, or the one given by HTTPS_PROXY or HTTP_PROXY if it's
+// nil. wrap, if given, wraps the connection before the handshake.
+func dialWebSocket(u *url.URL, header http.Header, proxyURL *url.URL, wrap func(net.Conn) io.ReadWriteCloser) (*websocket.Conn, error) {
+	origin := *u
+	origin.Scheme, origin.Path, origin.RawQuery = "https", "", ""
+	if u.Scheme == "ws" {
+		origin.Scheme = "http"
+	}
+	cfg, err := websocket.NewConfig(u.String(), origin.String())
+	if err != nil {
+		return nil, err
+	}
+	cfg.Header = header
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), map[string]string{"ws": "80", "wss": "443"}[u.Scheme])
+	}
+	conn, err := proxyDial(proxyURL, &url.URL{Scheme: origin.Scheme, Host: u.Host}, addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+	var rwc io.ReadWriteCloser = conn
+	if wrap != nil {
+		rwc = wrap(conn)
+	}
+	ws, err := websocket.NewClient(cfg, rwc)
+	if err != nil {
+		rwc.Close()
+		return nil, err
+	}
+	ws.MaxPayloadBytes = maxWebSocketMessage
+	return ws, nil
+}
+
+// readWebSocketMessage returns the next text or binary message from ws.
+// Pings are answered while it waits, and it returns io.EOF once the server
+// closes the connection.
+func readWebSocketMessage(ws *websocket.Conn) ([]byte, error) {
+	var msg []byte
+	err := websocket.Message.Receive(ws, &msg)
+	return msg, err
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+// webSocketServer serves a WebSocket which sends msgs to each client and
+// then closes, recording the Authorization header of the last handshake.
+func webSocketServer(msgs ...[]byte) (*httptest.Server, *atomic.Value) {
+	var auth atomic.Value
+	auth.Store("")
+	s := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		auth.Store(ws.Request().Header.Get("Authorization"))
+		for _, m := range msgs {
+			if websocket.Message.Send(ws, m) != nil {
+				return
+			}
+		}
+	}))
+	return s, &auth
+}
+
+func wsURL(t *testing.T, s *httptest.Server) *url.URL {
+	u, err := url.Parse(strings.Replace(s.URL, "http://", "ws://", 1) + "/subscribe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestDialWebSocket(t *testing.T) {
+	s, auth := webSocketServer([]byte(`{"kind":"commit"}`), []byte("second"))
+	defer s.Close()
+
+	ws, err := dialWebSocket(wsURL(t, s), http.Header{"Authorization": {"Bearer token"}}, nil, nil)
+	if err != nil {
+		t.Fatalf("dialWebSocket: %v", err)
+	}
+	defer ws.Close()
+	for _, want := range []string{`{"kind":"commit"}`, "second"} {
+		msg, err := readWebSocketMessage(ws)
+		if err != nil {
+			t.Fatalf("readWebSocketMessage: %v", err)
+		}
+		if string(msg) != want {
+			t.Errorf("got message %q, want %q", msg, want)
+		}
+	}
+	if _, err := readWebSocketMessage(ws); err != io.EOF {
+		t.Errorf("got %v once the server closed, want io.EOF", err)
+	}
+	if got := auth.Load().(string); got != "Bearer token" {
+		t.Errorf("server received Authorization %q, want %q", got, "Bearer token")
+	}
+}
+
+func TestDialWebSocketThroughProxy(t *testing.T) {
+	s, _ := webSocketServer([]byte("hello"))
+	defer s.Close()
+
+	var tunnelled atomic.Value
+	tunnelled.Store("")
+	p := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "Only CONNECT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		tunnelled.Store(r.Host)
+		up, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			up.Close()
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			io.Copy(up, rw)
+			up.Close()
+		}()
+		io.Copy(conn, up)
+		conn.Close()
+	}))
+	defer p.Close()
+
+	proxyURL, _ := url.Parse(p.URL)
+	u := wsURL(t, s)
+	ws, err := dialWebSocket(u, nil, proxyURL, nil)
+	if err != nil {
+		t.Fatalf("dialWebSocket: %v", err)
+	}
+	defer ws.Close()
+	msg, err := readWebSocketMessage(ws)
+	if err != nil || string(msg) != "hello" {
+		t.Errorf("got message %q, %v, want %q", msg, err, "hello")
+	}
+	if got := tunnelled.Load().(string); got != u.Host {
+		t.Errorf("proxy tunnelled to %q, want %q", got, u.Host)
+	}
+}
+
+func TestDialWebSocketProxyRefused(t *testing.T) {
+	p := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}))
+	defer p.Close()
+
+	proxyURL, _ := url.Parse(p.URL)
+	u, _ := url.Parse("ws://example.com/subscribe")
+	if _, err := dialWebSocket(u, nil, proxyURL, nil); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("got %v, want the proxy's 403 response", err)
+	}
+}
+
+func TestWebSocketMessageTooLarge(t *testing.T) {
+	s, _ := webSocketServer(make([]byte, maxWebSocketMessage+1))
+	defer s.Close()
+
+	ws, err := dialWebSocket(wsURL(t, s), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("dialWebSocket: %v", err)
+	}
+	defer ws.Close()
+	if _, err := readWebSocketMessage(ws); err != websocket.ErrFrameTooLarge {
+		t.Errorf("got %v, want %v", err, websocket.ErrFrameTooLarge)
+	}
+}
+
+// Servers must not mask their frames.
+// https://tools.ietf.org/html/rfc6455#section-5.1
+func TestWebSocketRejectsMaskedFrames(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		sum := sha1.Sum([]byte(req.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: "+base64.StdEncoding.EncodeToString(sum[:])+"\r\n\r\n")
+		// A masked text frame containing "hi".
+		mask := []byte{1, 2, 3, 4}
+		conn.Write(append([]byte{0x81, 0x80 | 2, 1, 2, 3, 4}, 'h'^mask[0], 'i'^mask[1]))
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	u, _ := url.Parse("ws://" + l.Addr().String() + "/")
+	ws, err := dialWebSocket(u, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("dialWebSocket: %v", err)
+	}
+	defer ws.Close()
+	if msg, err := readWebSocketMessage(ws); err == nil {
+		t.Errorf("got message %q from a masked frame, want an error", msg)
+	}
+}